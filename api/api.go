@@ -2,9 +2,23 @@ package api
 
 import (
 	"odo24_mobile_backend/api/handlers"
+	"odo24_mobile_backend/api/services"
+	account_service "odo24_mobile_backend/api/services/account"
+	auth_service "odo24_mobile_backend/api/services/auth"
 	car_services_service "odo24_mobile_backend/api/services/car_services"
 	cars_service "odo24_mobile_backend/api/services/cars"
+	export_service "odo24_mobile_backend/api/services/export"
 	groups_service "odo24_mobile_backend/api/services/groups"
+	import_service "odo24_mobile_backend/api/services/import"
+	notifications_service "odo24_mobile_backend/api/services/notifications"
+	reminders_service "odo24_mobile_backend/api/services/reminders"
+	report_service "odo24_mobile_backend/api/services/report"
+	sessions_service "odo24_mobile_backend/api/services/sessions"
+	statistics_service "odo24_mobile_backend/api/services/statistics"
+	verification_service "odo24_mobile_backend/api/services/verification"
+	"odo24_mobile_backend/api/utils"
+	"odo24_mobile_backend/config"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,21 +32,82 @@ func InitHandlers() *gin.Engine {
 	groupsSrv := groups_service.NewGroupsService()
 	carServicesSrv := car_services_service.NewCarServicesService()
 
+	cfg := config.GetInstance()
+	memc := services.NewMemcacheClient(cfg.Memcache.Addr)
+	denylist := services.NewTokenDenylist(cfg.Auth.DenylistStore, memc)
+	sessionsSrv := sessions_service.NewSessionsService(denylist, auth_service.DefaultAccessTokenExp, auth_service.DefaultRefreshTokenExp)
+	guard := services.NewBruteForceGuard(services.NewAttemptStore(cfg.Auth.AttemptsStore, memc))
+	verificationSrv := verification_service.NewVerificationService(services.NewCodeStore(cfg.Auth.CodeStore, memc))
+
+	hashCfg := cfg.PasswordHash
+	passwordHasher := utils.NewPasswordHasher(utils.Argon2Params{
+		Memory:      hashCfg.Memory,
+		Iterations:  hashCfg.Iterations,
+		Parallelism: hashCfg.Parallelism,
+	})
+
 	//register
-	registerCtrl := handlers.NewRegisterController()
+	registerCtrl := handlers.NewRegisterController(passwordHasher, sessionsSrv, guard, verificationSrv)
 	apiRegister := r.Group("/api/register")
 	apiRegister.POST("/register_send_code", registerCtrl.SendEmailCodeConfirmation)
 	apiRegister.POST("/register_by_email", registerCtrl.RegisterByEmail)
 	apiRegister.POST("/recover_send_code", registerCtrl.RecoverSendEmailCodeConfirmation)
 	apiRegister.POST("/recover_password", registerCtrl.RecoverPassword)
+	apiRegister.GET("/code_status", registerCtrl.CodeStatus)
 
 	//auth
-	authCtrl := handlers.NewAuthController()
+	authCtrl := handlers.NewAuthController(passwordHasher, sessionsSrv, guard)
+
+	r.GET("/.well-known/jwks.json", authCtrl.JWKS)
 
 	apiAuth := r.Group("/api/auth")
 	apiAuth.POST("/login", authCtrl.Login)
+	apiAuth.POST("/login/2fa", authCtrl.LoginTwoFactor)
+	apiAuth.POST("/oauth/:provider", authCtrl.OAuthLogin)
 	apiAuth.POST("/refresh_token", authCtrl.RefreshToken)
 	apiAuth.POST("/change_password", authCtrl.CheckAuth, authCtrl.ChangePassword)
+	apiAuth.POST("/change_email/send_code", authCtrl.CheckAuth, registerCtrl.ChangeEmailSendCode)
+	apiAuth.POST("/change_email", authCtrl.CheckAuth, registerCtrl.ChangeEmail)
+	apiAuth.POST("/logout", authCtrl.CheckAuth, authCtrl.Logout)
+	apiAuth.POST("/logout_all", authCtrl.CheckAuth, authCtrl.LogoutAll)
+
+	//2fa
+	apiTwoFactor := apiAuth.Group("/2fa", authCtrl.CheckAuth)
+	apiTwoFactor.POST("/enroll", authCtrl.TOTPEnroll)
+	apiTwoFactor.POST("/confirm", authCtrl.TOTPConfirm)
+	apiTwoFactor.POST("/disable", authCtrl.TOTPDisable)
+
+	//account
+	accountSrv := account_service.NewAccountService(passwordHasher, sessionsSrv, guard, verificationSrv, time.Duration(cfg.Account.DeletionGraceDays)*time.Hour*24)
+	go accountSrv.RunPurge(time.Hour)
+	go sessionsSrv.RunPrune(time.Hour)
+
+	accountCtrl := handlers.NewAccountController(accountSrv)
+	apiAccount := r.Group("/api/account", authCtrl.CheckAuth)
+	apiAccount.POST("/delete/send_code", accountCtrl.DeleteSendCode)
+	apiAccount.POST("/delete", accountCtrl.Delete)
+
+	//export
+	exportSrv := export_service.NewExportService(carsSrv, groupsSrv, carServicesSrv, cfg.Export.Dir)
+	go exportSrv.RunCleanup(time.Hour)
+
+	exportCtrl := handlers.NewExportController(exportSrv)
+	apiExport := r.Group("/api/export", authCtrl.CheckAuth)
+	apiExport.GET("", exportCtrl.Export)
+	apiExport.POST("", exportCtrl.StartAsync)
+	apiExport.GET("/:exportID", exportCtrl.GetJob)
+	apiExport.GET("/:exportID/download", exportCtrl.Download)
+
+	//import
+	importCtrl := handlers.NewImportController(import_service.NewImportService(carsSrv, groupsSrv, carServicesSrv))
+	r.POST("/api/import", authCtrl.CheckAuth, importCtrl.Import)
+
+	//sessions
+	sessionsCtrl := handlers.NewSessionsController(sessionsSrv)
+	apiSessions := apiAuth.Group("/sessions", authCtrl.CheckAuth)
+	apiSessions.GET("", sessionsCtrl.GetSessionsByCurrentUser)
+	apiSessions.DELETE("", sessionsCtrl.DeleteOthers)
+	apiSessions.DELETE("/:sessionID", sessionsCtrl.Delete)
 
 	//cars
 	carsCtrl := handlers.NewCarsController(carsSrv, groupsSrv)
@@ -43,8 +118,53 @@ func InitHandlers() *gin.Engine {
 	apiCarsID := apiCars.Group("/:carID", carsCtrl.CheckParamCarID)
 	apiCarsID.PUT("", carsCtrl.Update)
 	apiCarsID.PUT("/update_odo", carsCtrl.UpdateODO)
+	apiCarsID.GET("/odo_history", carsCtrl.GetOdoHistory)
 	apiCarsID.DELETE("", carsCtrl.Delete)
 
+	reportSrv, err := report_service.NewReportService(carsSrv, groupsSrv, carServicesSrv, cfg.Report.FontPath)
+	if err != nil {
+		panic(err)
+	}
+	reportCtrl := handlers.NewReportController(reportSrv)
+	apiCarsID.GET("/report.pdf", reportCtrl.CarServiceBook)
+
+	//notifications
+	pushProvider, err := notifications_service.NewProvider(notifications_service.ProviderOptions{
+		Endpoint:        cfg.Push.Endpoint,
+		AccessToken:     cfg.Push.AccessToken,
+		CredentialsFile: cfg.Push.CredentialsFile,
+		Timeout:         time.Duration(cfg.Push.TimeoutSeconds) * time.Second,
+	})
+	if err != nil {
+		panic(err)
+	}
+	notificationsSrv := notifications_service.NewNotificationsService(pushProvider, cfg.Push.MaxAttempts)
+	go notificationsSrv.RunCleanup(time.Hour * 24)
+
+	notificationsCtrl := handlers.NewNotificationsController(notificationsSrv)
+	apiNotifications := r.Group("/api/notifications", authCtrl.CheckAuth)
+	apiNotifications.GET("/devices", notificationsCtrl.GetDevices)
+	apiNotifications.POST("/devices", notificationsCtrl.RegisterDevice)
+	apiNotifications.DELETE("/devices", notificationsCtrl.UnregisterDevice)
+	apiNotifications.GET("/preferences", notificationsCtrl.GetPreferences)
+	apiNotifications.PUT("/preferences", notificationsCtrl.UpdatePreferences)
+	apiNotifications.POST("/test", notificationsCtrl.Test)
+
+	//reminders
+	if cfg.Reminders.Enabled {
+		remindersSrv := reminders_service.NewRemindersService(carsSrv, groupsSrv, notificationsSrv, reminders_service.ReminderOptions{
+			DistanceKm:     cfg.Reminders.DistanceKm,
+			Days:           cfg.Reminders.Days,
+			MaxOverdueKm:   cfg.Reminders.MaxOverdueKm,
+			MaxOverdueDays: cfg.Reminders.MaxOverdueDays,
+		})
+		interval := time.Duration(cfg.Reminders.IntervalMinutes) * time.Minute
+		if interval <= 0 {
+			interval = time.Hour
+		}
+		go remindersSrv.RunScheduler(interval)
+	}
+
 	//groups
 
 	groupsCtrl := handlers.NewGroupsController(groupsSrv)
@@ -62,9 +182,17 @@ func InitHandlers() *gin.Engine {
 	apiServiceCtrl := apiCarsID.Group("/groups/:groupID/services", groupsCtrl.CheckParamGroupID)
 	apiServiceCtrl.GET("", carServicesCtrl.GetServicesByCurrentUserAndGroup)
 	apiServiceCtrl.POST("", carServicesCtrl.Create)
+	apiCarsID.GET("/services", carServicesCtrl.GetCarTimeline)
+	r.GET("/api/search", authCtrl.CheckAuth, carServicesCtrl.Search)
+
 	apiServiceCtrlID := r.Group("/api/services/:serviceID", authCtrl.CheckAuth, carServicesCtrl.CheckParamServiceID)
 	apiServiceCtrlID.PUT("", carServicesCtrl.Update)
 	apiServiceCtrlID.DELETE("", carServicesCtrl.Delete)
 
+	//statistics
+	statisticsCtrl := handlers.NewStatisticsController(statistics_service.NewStatisticsService())
+	r.GET("/api/statistics", authCtrl.CheckAuth, statisticsCtrl.GetByCurrentUser)
+	apiCarsID.GET("/statistics", statisticsCtrl.GetByCar)
+
 	return r
 }
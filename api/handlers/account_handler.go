@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	account_service "odo24_mobile_backend/api/services/account"
+	"odo24_mobile_backend/api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountController struct {
+	service *account_service.AccountService
+}
+
+func NewAccountController(srv *account_service.AccountService) *AccountController {
+	return &AccountController{
+		service: srv,
+	}
+}
+
+func (ctrl *AccountController) DeleteSendCode(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	err := ctrl.service.DeleteSendCode(userID)
+	if err != nil {
+		if bindResendCooldown(c, err) {
+			return
+		}
+		utils.BindServiceErrorWithAbort(c, "DeleteSendCodeError", "Не удалось отправить сообщение на почту", err)
+		return
+	}
+
+	utils.BindNoContent(c)
+}
+
+// Delete ответ 202 с датой удаления при отсрочке, иначе 200 после удаления данных
+func (ctrl *AccountController) Delete(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	var body struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "", err)
+		return
+	}
+
+	result, err := ctrl.service.Delete(userID, body.Password, body.Code, c.ClientIP())
+	if err != nil {
+		if bindTooManyAttempts(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, account_service.ErrConfirmationRequired):
+			utils.BindBadRequestWithAbort(c, "Требуется пароль или код подтверждения", err)
+		case errors.Is(err, account_service.ErrInvalidPassword):
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "InvalidPassword", "Неверный пароль", err)
+		case errors.Is(err, account_service.ErrCodeDoesNotMatch):
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "ConfirmCodeError", "Неверный код подтверждения", err)
+		default:
+			utils.BindServiceErrorWithAbort(c, "AccountDeleteError", "Ошибка удаления аккаунта", err)
+		}
+		return
+	}
+
+	if result.Deleted {
+		c.JSON(http.StatusOK, result)
+	} else {
+		c.JSON(http.StatusAccepted, result)
+	}
+}
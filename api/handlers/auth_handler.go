@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"odo24_mobile_backend/api/services"
 	auth_service "odo24_mobile_backend/api/services/auth"
+	oauth_service "odo24_mobile_backend/api/services/oauth"
+	sessions_service "odo24_mobile_backend/api/services/sessions"
 	"odo24_mobile_backend/api/utils"
 	"odo24_mobile_backend/config"
 	"strings"
@@ -15,13 +17,27 @@ import (
 )
 
 type AuthController struct {
-	service *auth_service.AuthService
+	service          *auth_service.AuthService
+	oauthProviders   map[string]oauth_service.Provider
+	denylistFailOpen bool
 }
 
-func NewAuthController() *AuthController {
-	cfg := config.GetInstance().App
+func NewAuthController(hasher *utils.PasswordHasher, sessionsSrv *sessions_service.SessionsService, guard *services.BruteForceGuard) *AuthController {
+	options := config.GetInstance()
+
+	accessKeys, err := auth_service.NewKeyRing(options.AccessKeyRing())
+	if err != nil {
+		panic(err)
+	}
+	refreshKeys, err := auth_service.NewKeyRing(options.RefreshKeyRing())
+	if err != nil {
+		panic(err)
+	}
+
 	return &AuthController{
-		service: auth_service.NewAuthService(cfg.JwtAccessPrivateKeyPath, cfg.JwtAccessPublicKeyPath, cfg.JwtRefreshPrivateKeyPath, cfg.JwtRefreshPublicKeyPath),
+		service:          auth_service.NewAuthService(hasher, sessionsSrv, accessKeys, refreshKeys, guard),
+		oauthProviders:   oauth_service.NewProviders(options.Oauth),
+		denylistFailOpen: options.Auth.DenylistFailOpen,
 	}
 }
 
@@ -52,14 +68,41 @@ func (ctrl *AuthController) CheckAuth(c *gin.Context) {
 		return
 	}
 
+	tokenUUID := claims["uuid"].(string)
+
+	// без хранилища отозванных токенов отказ, если явно не разрешено обратное (auth.denylist_fail_open)
+	revoked, err := ctrl.service.IsAccessTokenRevoked(tokenUUID)
+	if err != nil {
+		log.Printf("check token denylist error: %v\n", err)
+		if !ctrl.denylistFailOpen {
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+	} else if revoked {
+		log.Println("check token error: token revoked")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
 	c.Set("userID", uint64(claims["uid"].(float64)))
+	c.Set("tokenUUID", tokenUUID)
 	c.Next()
 }
 
+// clientInfo данные устройства для сессии
+func clientInfo(c *gin.Context, deviceName string) sessions_service.ClientInfo {
+	return sessions_service.ClientInfo{
+		DeviceName: deviceName,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+	}
+}
+
 func (ctrl *AuthController) Login(c *gin.Context) {
 	var body struct {
-		Email    string `json:"login" binding:"required,email"`
-		Password string `json:"password" binding:"required"`
+		Email      string `json:"login" binding:"required,email"`
+		Password   string `json:"password" binding:"required"`
+		DeviceName string `json:"device_name"`
 	}
 	err := c.ShouldBindJSON(&body)
 	if err != nil {
@@ -67,8 +110,11 @@ func (ctrl *AuthController) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := ctrl.service.Login(body.Email, body.Password)
+	token, err := ctrl.service.Login(body.Email, body.Password, clientInfo(c, body.DeviceName))
 	if err != nil {
+		if bindTwoFactorChallenge(c, err) || bindTooManyAttempts(c, err) {
+			return
+		}
 		if errors.Is(err, services.ErrorUnauthorize) {
 			utils.BindErrorWithAbort(c, http.StatusUnauthorized, "AuthError", "Неверный логин или пароль", nil)
 		} else {
@@ -80,6 +126,175 @@ func (ctrl *AuthController) Login(c *gin.Context) {
 	c.JSON(http.StatusOK, token)
 }
 
+// bindTooManyAttempts ответ 429, если превышено число попыток
+func bindTooManyAttempts(c *gin.Context, err error) bool {
+	var limitErr *services.TooManyAttemptsError
+	if !errors.As(err, &limitErr) {
+		return false
+	}
+
+	utils.BindTooManyRequestsWithAbort(c, "TooManyAttempts", "Слишком много попыток, повторите позже", limitErr.RetryAfter, err)
+	return true
+}
+
+// bindTwoFactorChallenge ответ с challenge токеном, если для входа нужен код 2FA
+func bindTwoFactorChallenge(c *gin.Context, err error) bool {
+	var challengeErr *auth_service.TwoFactorRequiredError
+	if !errors.As(err, &challengeErr) {
+		return false
+	}
+
+	c.JSON(http.StatusOK, challengeErr.Challenge)
+	return true
+}
+
+func (ctrl *AuthController) LoginTwoFactor(c *gin.Context) {
+	var body struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required"`
+		DeviceName     string `json:"device_name"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "", err)
+		return
+	}
+
+	token, err := ctrl.service.LoginTwoFactor(body.ChallengeToken, body.Code, clientInfo(c, body.DeviceName))
+	if err != nil {
+		if bindTooManyAttempts(c, err) {
+			return
+		}
+		if errors.Is(err, auth_service.ErrInvalidTOTPCode) {
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "InvalidTOTPCode", "Неверный код подтверждения", err)
+		} else if errors.Is(err, auth_service.ErrInvalidChallengeToken) || errors.Is(err, auth_service.ErrInvalidTokenType) || errors.Is(err, auth_service.ErrTOTPNotEnabled) {
+			utils.BindErrorWithAbort(c, http.StatusUnauthorized, "ChallengeTokenError", "Время подтверждения истекло, авторизуйтесь заново", err)
+		} else {
+			utils.BindServiceErrorWithAbort(c, "LoginError", "Произошла ошибка при авторизации", err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+func (ctrl *AuthController) TOTPEnroll(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	result, err := ctrl.service.TOTPEnroll(userID)
+	if err != nil {
+		if errors.Is(err, auth_service.ErrTOTPAlreadyEnabled) {
+			utils.BindErrorWithAbort(c, http.StatusConflict, "TOTPAlreadyEnabled", "Двухфакторная авторизация уже включена", err)
+		} else {
+			utils.BindServiceErrorWithAbort(c, "TOTPEnrollError", "Не удалось подключить двухфакторную авторизацию", err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (ctrl *AuthController) TOTPConfirm(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	var body struct {
+		Code string `json:"code" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "", err)
+		return
+	}
+
+	result, err := ctrl.service.TOTPConfirm(userID, body.Code, c.ClientIP())
+	if err != nil {
+		if bindTooManyAttempts(c, err) {
+			return
+		}
+		if errors.Is(err, auth_service.ErrInvalidTOTPCode) {
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "InvalidTOTPCode", "Неверный код подтверждения", err)
+		} else if errors.Is(err, auth_service.ErrTOTPAlreadyEnabled) {
+			utils.BindErrorWithAbort(c, http.StatusConflict, "TOTPAlreadyEnabled", "Двухфакторная авторизация уже включена", err)
+		} else if errors.Is(err, auth_service.ErrTOTPNotEnrolled) {
+			utils.BindErrorWithAbort(c, http.StatusConflict, "TOTPNotEnrolled", "Сначала получите секрет для приложения", err)
+		} else {
+			utils.BindServiceErrorWithAbort(c, "TOTPConfirmError", "Не удалось подключить двухфакторную авторизацию", err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (ctrl *AuthController) TOTPDisable(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	var body struct {
+		Code string `json:"code" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "", err)
+		return
+	}
+
+	err = ctrl.service.TOTPDisable(userID, body.Code, c.ClientIP())
+	if err != nil {
+		if bindTooManyAttempts(c, err) {
+			return
+		}
+		if errors.Is(err, auth_service.ErrInvalidTOTPCode) {
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "InvalidTOTPCode", "Неверный код подтверждения", err)
+		} else if errors.Is(err, auth_service.ErrTOTPNotEnabled) {
+			utils.BindErrorWithAbort(c, http.StatusConflict, "TOTPNotEnabled", "Двухфакторная авторизация не включена", err)
+		} else {
+			utils.BindServiceErrorWithAbort(c, "TOTPDisableError", "Не удалось отключить двухфакторную авторизацию", err)
+		}
+		return
+	}
+
+	utils.BindNoContent(c)
+}
+
+func (ctrl *AuthController) OAuthLogin(c *gin.Context) {
+	provider, ok := ctrl.oauthProviders[c.Param("provider")]
+	if !ok {
+		utils.BindErrorWithAbort(c, http.StatusNotFound, "OAuthProviderNotFound", "Неизвестный провайдер авторизации", nil)
+		return
+	}
+
+	var body struct {
+		Code       string `json:"code" binding:"required"`
+		DeviceName string `json:"device_name"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "", err)
+		return
+	}
+
+	token, err := ctrl.service.LoginByOAuth(provider, body.Code, clientInfo(c, body.DeviceName))
+	if err != nil {
+		if bindTwoFactorChallenge(c, err) {
+			return
+		}
+		if errors.Is(err, oauth_service.ErrInvalidCode) {
+			utils.BindErrorWithAbort(c, http.StatusUnauthorized, "OAuthCodeError", "Неверный код авторизации", err)
+		} else if errors.Is(err, oauth_service.ErrEmailNotProvided) {
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "OAuthEmailError", "Провайдер не предоставил E-mail", err)
+		} else if errors.Is(err, oauth_service.ErrIDNotProvided) {
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "OAuthIDError", "Провайдер не предоставил идентификатор пользователя", err)
+		} else if errors.Is(err, oauth_service.ErrEmailNotVerified) {
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "OAuthEmailNotVerified", "E-mail не подтвержден провайдером, войдите по паролю", err)
+		} else {
+			utils.BindServiceErrorWithAbort(c, "OAuthLoginError", "Произошла ошибка при авторизации", err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
 func (ctrl *AuthController) RefreshToken(c *gin.Context) {
 	bearerToken := c.Request.Header.Get("Authorization")
 	splitToken := strings.Split(bearerToken, " ")
@@ -99,7 +314,7 @@ func (ctrl *AuthController) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	result, err := ctrl.service.RefreshToken(accessToken, body.RefreshToken)
+	result, err := ctrl.service.RefreshToken(accessToken, body.RefreshToken, clientInfo(c, ""))
 	if err != nil {
 		if errors.Is(err, services.ErrorUnauthorize) {
 			utils.BindErrorWithAbort(c, http.StatusUnauthorized, "RefreshError", "Ошибка обновления токена. Попробуйте переавторизоваться", err)
@@ -114,6 +329,7 @@ func (ctrl *AuthController) RefreshToken(c *gin.Context) {
 
 func (ctrl *AuthController) ChangePassword(c *gin.Context) {
 	userID := c.MustGet("userID").(uint64)
+	tokenUUID := c.MustGet("tokenUUID").(string)
 
 	var body struct {
 		CurrentPassword string `json:"current_password" binding:"required"`
@@ -126,11 +342,46 @@ func (ctrl *AuthController) ChangePassword(c *gin.Context) {
 		return
 	}
 
-	err = ctrl.service.ChangePassword(userID, body.CurrentPassword, body.NewPassword)
+	err = ctrl.service.ChangePassword(userID, tokenUUID, body.CurrentPassword, body.NewPassword)
+	if err != nil {
+		if errors.Is(err, auth_service.ErrInvalidPassword) {
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "InvalidPassword", "Неверный текущий пароль", err)
+		} else {
+			utils.BindServiceErrorWithAbort(c, "ChangePasswordError", "Ошибка изменения пароля", err)
+		}
+		return
+	}
+
+	utils.BindNoContent(c)
+}
+
+func (ctrl *AuthController) Logout(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+	tokenUUID := c.MustGet("tokenUUID").(string)
+
+	err := ctrl.service.Logout(userID, tokenUUID)
 	if err != nil {
-		utils.BindServiceErrorWithAbort(c, "ChangePasswordError", "Ошибка изменения пароля", err)
+		utils.BindServiceErrorWithAbort(c, "LogoutError", "Ошибка выхода", err)
 		return
 	}
 
 	utils.BindNoContent(c)
 }
+
+func (ctrl *AuthController) LogoutAll(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	err := ctrl.service.LogoutAll(userID)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "LogoutError", "Ошибка выхода", err)
+		return
+	}
+
+	utils.BindNoContent(c)
+}
+
+// JWKS публичные ключи access токенов
+func (ctrl *AuthController) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ctrl.service.AccessJWKS())
+}
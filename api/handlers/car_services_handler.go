@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	car_services_service "odo24_mobile_backend/api/services/car_services"
 	"odo24_mobile_backend/api/utils"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,10 +21,20 @@ func NewCarServicesController(srv *car_services_service.CarServicesService) *Car
 	}
 }
 
+/*
+GetServicesByCurrentUserAndGroup без параметров запроса - прежний ответ массивом всех записей,
+с любым параметром - страница {items, next_cursor, has_more}, к которой применяются сортировка и фильтры.
+Так фильтр без limit не возвращает молча нефильтрованный массив
+*/
 func (ctrl *CarServicesController) GetServicesByCurrentUserAndGroup(c *gin.Context) {
 	groupID := c.MustGet("groupID").(uint64)
 	carID := c.MustGet("carID").(uint64)
 
+	if len(c.Request.URL.Query()) > 0 {
+		ctrl.getServicesPage(c, carID, groupID)
+		return
+	}
+
 	services, err := ctrl.service.GetServices(carID, groupID)
 	if err != nil {
 		utils.BindServiceErrorWithAbort(c, "GetServices", "Не удалось получить список записей", err)
@@ -43,6 +55,7 @@ func (ctrl *CarServicesController) Create(c *gin.Context) {
 	var body struct {
 		Odo          *uint32 `json:"odo" binding:"omitempty"`
 		NextDistance *uint32 `json:"next_distance" binding:"omitempty"`
+		NextMonths   *uint32 `json:"next_months" binding:"omitempty,min=1,max=240"`
 		Dt           string  `json:"dt" binding:"required"`
 		Description  *string `json:"description" binding:"omitempty"`
 		Price        *uint32 `json:"price" binding:"omitempty"`
@@ -58,6 +71,7 @@ func (ctrl *CarServicesController) Create(c *gin.Context) {
 		GroupID:      groupID,
 		Odo:          body.Odo,
 		NextDistance: body.NextDistance,
+		NextMonths:   body.NextMonths,
 		Dt:           body.Dt,
 		Description:  body.Description,
 		Price:        body.Price,
@@ -77,6 +91,7 @@ func (ctrl *CarServicesController) Update(c *gin.Context) {
 	var body struct {
 		Odo          *uint32 `json:"odo" binding:"omitempty"`
 		NextDistance *uint32 `json:"next_distance" binding:"omitempty"`
+		NextMonths   *uint32 `json:"next_months" binding:"omitempty,min=1,max=240"`
 		Dt           string  `json:"dt" binding:"required"`
 		Description  *string `json:"description" binding:"omitempty"`
 		Price        *uint32 `json:"price" binding:"omitempty"`
@@ -91,6 +106,7 @@ func (ctrl *CarServicesController) Update(c *gin.Context) {
 		ServiceID:    serviceID,
 		Odo:          body.Odo,
 		NextDistance: body.NextDistance,
+		NextMonths:   body.NextMonths,
 		Dt:           body.Dt,
 		Description:  body.Description,
 		Price:        body.Price,
@@ -139,3 +155,129 @@ func (ctrl *CarServicesController) CheckParamServiceID(c *gin.Context) {
 
 	c.Set("serviceID", serviceID)
 }
+
+func (ctrl *CarServicesController) getServicesPage(c *gin.Context, carID, groupID uint64) {
+	query, ok := bindServicesQuery(c)
+	if !ok {
+		return
+	}
+
+	page, err := ctrl.service.GetServicesPage(carID, groupID, query)
+	if err != nil {
+		bindServicesQueryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// GetCarTimeline записи авто по всем группам, group_id - необязательный фильтр, можно указать несколько раз
+func (ctrl *CarServicesController) GetCarTimeline(c *gin.Context) {
+	carID := c.MustGet("carID").(uint64)
+
+	query, ok := bindServicesQuery(c)
+	if !ok {
+		return
+	}
+
+	var groupIDs []uint64
+	for _, value := range c.QueryArray("group_id") {
+		groupID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			utils.BindBadRequestWithAbort(c, "Ошибка парсинга группы", err)
+			return
+		}
+		groupIDs = append(groupIDs, groupID)
+	}
+
+	page, err := ctrl.service.GetCarTimeline(carID, groupIDs, query)
+	if err != nil {
+		bindServicesQueryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// Search полнотекстовый поиск по записям всех авто пользователя
+func (ctrl *CarServicesController) Search(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	var params struct {
+		Query  string  `form:"q" binding:"required"`
+		CarID  *uint64 `form:"car_id"`
+		Limit  int     `form:"limit" binding:"omitempty,min=1,max=100"`
+		Cursor string  `form:"cursor"`
+	}
+	err := c.ShouldBindQuery(&params)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "Некорректные параметры запроса", err)
+		return
+	}
+
+	query := strings.TrimSpace(params.Query)
+	if query == "" {
+		utils.BindBadRequestWithAbort(c, "Пустой поисковый запрос", nil)
+		return
+	}
+
+	page, err := ctrl.service.Search(userID, car_services_service.SearchQueryModel{
+		Query:  query,
+		CarID:  params.CarID,
+		Limit:  params.Limit,
+		Cursor: params.Cursor,
+	})
+	if err != nil {
+		bindServicesQueryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// bindServicesQuery разбор параметров пагинации, сортировки и фильтров записей
+func bindServicesQuery(c *gin.Context) (car_services_service.ServicesQueryModel, bool) {
+	var params struct {
+		Limit       int     `form:"limit" binding:"omitempty,min=1,max=200"`
+		Cursor      string  `form:"cursor"`
+		Sort        string  `form:"sort" binding:"omitempty,oneof=dt odo price"`
+		Order       string  `form:"order" binding:"omitempty,oneof=asc desc"`
+		DateFrom    *string `form:"date_from" binding:"omitempty,datetime=2006-01-02"`
+		DateTo      *string `form:"date_to" binding:"omitempty,datetime=2006-01-02"`
+		OdoFrom     *uint32 `form:"odo_from"`
+		OdoTo       *uint32 `form:"odo_to"`
+		PriceFrom   *uint32 `form:"price_from"`
+		PriceTo     *uint32 `form:"price_to"`
+		Description string  `form:"q"`
+	}
+	err := c.ShouldBindQuery(&params)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "Некорректные параметры запроса", err)
+		return car_services_service.ServicesQueryModel{}, false
+	}
+
+	return car_services_service.ServicesQueryModel{
+		Limit:       params.Limit,
+		Cursor:      params.Cursor,
+		Sort:        params.Sort,
+		Order:       params.Order,
+		DateFrom:    params.DateFrom,
+		DateTo:      params.DateTo,
+		OdoFrom:     params.OdoFrom,
+		OdoTo:       params.OdoTo,
+		PriceFrom:   params.PriceFrom,
+		PriceTo:     params.PriceTo,
+		Description: strings.TrimSpace(params.Description),
+	}, true
+}
+
+func bindServicesQueryError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, car_services_service.ErrInvalidCursor):
+		utils.BindBadRequestWithAbort(c, "Некорректный курсор", err)
+	case errors.Is(err, car_services_service.ErrInvalidSort):
+		utils.BindBadRequestWithAbort(c, "Некорректная сортировка", err)
+	default:
+		utils.BindServiceErrorWithAbort(c, "GetServices", "Не удалось получить список записей", err)
+	}
+}
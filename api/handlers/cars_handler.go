@@ -7,6 +7,7 @@ import (
 	groups_service "odo24_mobile_backend/api/services/groups"
 	"odo24_mobile_backend/api/utils"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -42,6 +43,11 @@ func (ctrl *CarsController) GetCarsByCurrentUser(c *gin.Context) {
 		carIDs = append(carIDs, cars[i].CarID)
 	}
 
+	forecast, err := ctrl.service.GetMileageForecast(carIDs)
+	if err != nil {
+		log.Printf("GetMileageForecast error: %v", err)
+	}
+
 	info, err := ctrl.service.GetCarNextServiceInformation(carIDs)
 	if err != nil {
 		log.Printf("getCarNextServiceInformation error: %v", err)
@@ -67,6 +73,7 @@ func (ctrl *CarsController) GetCarsByCurrentUser(c *gin.Context) {
 					mapGroups[groups[i].GroupID] = groups[i]
 				}
 
+				now := time.Now()
 				extInfo := make(map[uint64][]cars_service.CarExtData)
 				for carID := range info {
 					if _, ok := extInfo[carID]; !ok {
@@ -81,11 +88,19 @@ func (ctrl *CarsController) GetCarsByCurrentUser(c *gin.Context) {
 							groupName = "Group " + strconv.FormatUint(groupID, 10)
 						}
 
-						extInfo[carID] = append(extInfo[carID], cars_service.CarExtData{
-							Odo:       data.Odo,
-							NextOdo:   data.NextOdo,
-							GroupName: groupName,
-						})
+						extData := cars_service.CarExtData{
+							GroupName:  groupName,
+							NextMonths: data.NextMonths,
+							Dt:         data.Dt,
+							NextDate:   data.NextDate,
+						}
+						if data.Odo != nil && data.NextOdo != nil {
+							extData.Odo = *data.Odo
+							extData.NextOdo = *data.NextOdo
+							extData.NextDistance = data.NextOdo
+						}
+						extData.SetDue(forecast[carID], now)
+						extInfo[carID] = append(extInfo[carID], extData)
 					}
 				}
 
@@ -180,6 +195,31 @@ func (ctrl *CarsController) UpdateODO(c *gin.Context) {
 	utils.BindNoContent(c)
 }
 
+func (ctrl *CarsController) GetOdoHistory(c *gin.Context) {
+	carID := c.MustGet("carID").(uint64)
+
+	readings, err := ctrl.service.GetOdoHistory(carID)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "GetOdoHistoryError", "Не удалось получить историю пробега", err)
+		return
+	}
+
+	forecast, err := ctrl.service.GetMileageForecast([]uint64{carID})
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "GetOdoHistoryError", "Не удалось получить историю пробега", err)
+		return
+	}
+
+	result := cars_service.OdoHistoryModel{
+		Readings: readings,
+	}
+	if f := forecast[carID]; f != nil {
+		result.DailyDistance = &f.DailyDistance
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
 func (ctrl *CarsController) Delete(c *gin.Context) {
 	carID := c.MustGet("carID").(uint64)
 
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	export_service "odo24_mobile_backend/api/services/export"
+	"odo24_mobile_backend/api/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ExportController struct {
+	service *export_service.ExportService
+}
+
+func NewExportController(srv *export_service.ExportService) *ExportController {
+	return &ExportController{
+		service: srv,
+	}
+}
+
+// Export архив с данными пользователя, формируется в ответ на запрос
+func (ctrl *ExportController) Export(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	data, err := ctrl.service.Collect(userID)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "ExportError", "Ошибка выгрузки", err)
+		return
+	}
+
+	setAttachmentHeaders(c, fmt.Sprintf("odo24_export_%s.zip", time.Now().Format("2006-01-02")))
+	c.Status(http.StatusOK)
+
+	err = export_service.WriteArchive(data, c.Writer)
+	if err != nil {
+		// заголовки уже отправлены, остается оборвать ответ
+		log.Printf("export user_id=%d error: %v", userID, err)
+		c.Abort()
+	}
+}
+
+// StartAsync фоновая выгрузка, по готовности приходит письмо
+func (ctrl *ExportController) StartAsync(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	job, err := ctrl.service.StartAsync(userID)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "ExportError", "Не удалось начать выгрузку", err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (ctrl *ExportController) GetJob(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	job, err := ctrl.service.GetJob(userID, c.Param("exportID"))
+	if err != nil {
+		bindExportError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (ctrl *ExportController) Download(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	file, err := ctrl.service.OpenJobFile(userID, c.Param("exportID"))
+	if err != nil {
+		bindExportError(c, err)
+		return
+	}
+	defer file.Close()
+
+	setAttachmentHeaders(c, "odo24_export.zip")
+	c.Status(http.StatusOK)
+
+	_, err = io.Copy(c.Writer, file)
+	if err != nil {
+		log.Printf("download export error: %v", err)
+	}
+}
+
+func bindExportError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, export_service.ErrExportNotFound):
+		utils.BindErrorWithAbort(c, http.StatusNotFound, "ExportNotFound", "Выгрузка не найдена", err)
+	case errors.Is(err, export_service.ErrExportNotReady):
+		utils.BindErrorWithAbort(c, http.StatusConflict, "ExportNotReady", "Выгрузка еще не готова", err)
+	default:
+		utils.BindServiceErrorWithAbort(c, "ExportError", "Ошибка выгрузки", err)
+	}
+}
+
+func setAttachmentHeaders(c *gin.Context, fileName string) {
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+}
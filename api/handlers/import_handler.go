@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	import_service "odo24_mobile_backend/api/services/import"
+	"odo24_mobile_backend/api/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxImportFileSize = 10 << 20
+
+type ImportController struct {
+	service *import_service.ImportService
+}
+
+func NewImportController(srv *import_service.ImportService) *ImportController {
+	return &ImportController{
+		service: srv,
+	}
+}
+
+// Import файл в поле file формы multipart/form-data, либо телом запроса. dry_run=true - только отчет без сохранения
+func (ctrl *ImportController) Import(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize)
+
+	body, err := readImportFile(c)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			utils.BindErrorWithAbort(c, http.StatusRequestEntityTooLarge, "ImportFileTooLarge", "Слишком большой файл", err)
+			return
+		}
+		utils.BindBadRequestWithAbort(c, "Не удалось прочитать файл", err)
+		return
+	}
+
+	report, err := ctrl.service.Import(userID, body, dryRun)
+	if err != nil {
+		switch {
+		case errors.Is(err, import_service.ErrEmptyFile):
+			utils.BindBadRequestWithAbort(c, "Файл не содержит записей", err)
+		case errors.Is(err, import_service.ErrUnknownFormat):
+			utils.BindBadRequestWithAbort(c, "Неизвестный формат файла", err)
+		case errors.Is(err, import_service.ErrEntryTooLarge):
+			utils.BindErrorWithAbort(c, http.StatusRequestEntityTooLarge, "ImportEntryTooLarge", "Слишком большой файл в архиве", err)
+		case errors.Is(err, import_service.ErrTooManyRows):
+			utils.BindErrorWithAbort(c, http.StatusRequestEntityTooLarge, "ImportTooManyRows", "Слишком много записей в файле", err)
+		default:
+			utils.BindServiceErrorWithAbort(c, "ImportError", "Ошибка импорта", err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func readImportFile(c *gin.Context) ([]byte, error) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		if errors.Is(err, http.ErrNotMultipart) {
+			return io.ReadAll(c.Request.Body)
+		}
+		return nil, err
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	notifications_service "odo24_mobile_backend/api/services/notifications"
+	"odo24_mobile_backend/api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NotificationsController struct {
+	service *notifications_service.NotificationsService
+}
+
+func NewNotificationsController(srv *notifications_service.NotificationsService) *NotificationsController {
+	return &NotificationsController{
+		service: srv,
+	}
+}
+
+func (ctrl *NotificationsController) GetDevices(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+	tokenUUID := c.MustGet("tokenUUID").(string)
+
+	devices, err := ctrl.service.GetDevices(userID, tokenUUID)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "GetDevicesError", "Не удалось получить список устройств", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, devices)
+}
+
+// RegisterDevice токен устройства привязывается к текущей сессии
+func (ctrl *NotificationsController) RegisterDevice(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+	tokenUUID := c.MustGet("tokenUUID").(string)
+
+	var body struct {
+		Token    string `json:"token" binding:"required,max=4096"`
+		Platform string `json:"platform" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "", err)
+		return
+	}
+
+	err = ctrl.service.RegisterDevice(userID, tokenUUID, body.Platform, body.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, notifications_service.ErrUnknownPlatform):
+			utils.BindBadRequestWithAbort(c, "Неизвестная платформа устройства", err)
+		case errors.Is(err, notifications_service.ErrSessionNotFound):
+			utils.BindErrorWithAbort(c, http.StatusUnauthorized, "SessionNotFound", "Сессия не найдена", err)
+		default:
+			utils.BindServiceErrorWithAbort(c, "RegisterDeviceError", "Не удалось зарегистрировать устройство", err)
+		}
+		return
+	}
+
+	utils.BindNoContent(c)
+}
+
+func (ctrl *NotificationsController) UnregisterDevice(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	var body struct {
+		Token string `json:"token" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "", err)
+		return
+	}
+
+	err = ctrl.service.UnregisterDevice(userID, body.Token)
+	if err != nil {
+		if errors.Is(err, notifications_service.ErrDeviceNotFound) {
+			utils.BindErrorWithAbort(c, http.StatusNotFound, "DeviceNotFound", "Устройство не найдено", err)
+		} else {
+			utils.BindServiceErrorWithAbort(c, "UnregisterDeviceError", "Не удалось удалить устройство", err)
+		}
+		return
+	}
+
+	utils.BindNoContent(c)
+}
+
+func (ctrl *NotificationsController) GetPreferences(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	prefs, err := ctrl.service.GetPreferences(userID)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "GetPreferencesError", "Не удалось получить настройки уведомлений", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, prefs)
+}
+
+func (ctrl *NotificationsController) UpdatePreferences(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	var body notifications_service.PreferencesModel
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "", err)
+		return
+	}
+
+	err = ctrl.service.UpdatePreferences(userID, body)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "UpdatePreferencesError", "Не удалось сохранить настройки уведомлений", err)
+		return
+	}
+
+	utils.BindNoContent(c)
+}
+
+// Test тестовое уведомление на все устройства пользователя
+func (ctrl *NotificationsController) Test(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	delivered, err := ctrl.service.NotifyUser(userID, notifications_service.PushMessage{
+		Kind:  notifications_service.KindTest,
+		Title: "odo24.ru",
+		Body:  "Тестовое уведомление",
+	})
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "PushError", "Не удалось отправить уведомление", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, notifications_service.NotifyResultModel{Delivered: delivered})
+}
@@ -4,12 +4,13 @@ import (
 	"errors"
 	"net/http"
 	"net/mail"
-	"time"
 
+	"odo24_mobile_backend/api/services"
 	register_service "odo24_mobile_backend/api/services/register"
+	sessions_service "odo24_mobile_backend/api/services/sessions"
+	verification_service "odo24_mobile_backend/api/services/verification"
 	"odo24_mobile_backend/api/utils"
 
-	"github.com/bradfitz/gomemcache/memcache"
 	"github.com/gin-gonic/gin"
 )
 
@@ -17,15 +18,24 @@ type RegisterController struct {
 	service *register_service.RegisterService
 }
 
-func NewRegisterController() *RegisterController {
+func NewRegisterController(hasher *utils.PasswordHasher, sessionsSrv *sessions_service.SessionsService, guard *services.BruteForceGuard, verificationSrv *verification_service.VerificationService) *RegisterController {
 	return &RegisterController{
-		service: register_service.NewRegisterService(),
+		service: register_service.NewRegisterService(hasher, sessionsSrv, guard, verificationSrv),
 	}
 }
 
-func (ctrl *RegisterController) SendEmailCodeConfirmation(c *gin.Context) {
-	time.Sleep(time.Second)
+// bindResendCooldown ответ 429, если код уже отправлен и повторная отправка пока недоступна
+func bindResendCooldown(c *gin.Context, err error) bool {
+	var cooldownErr *verification_service.ResendCooldownError
+	if !errors.As(err, &cooldownErr) {
+		return false
+	}
+
+	utils.BindTooManyRequestsWithAbort(c, "CodeHasAlreadyBeenSent", "Код подтверждения уже был отправлен", cooldownErr.RetryAfter, err)
+	return true
+}
 
+func (ctrl *RegisterController) SendEmailCodeConfirmation(c *gin.Context) {
 	var body struct {
 		Email string `json:"email" binding:"required,email"`
 	}
@@ -41,18 +51,19 @@ func (ctrl *RegisterController) SendEmailCodeConfirmation(c *gin.Context) {
 		return
 	}
 
-	err = ctrl.service.SendEmailCodeConfirmation(emailAddr)
+	result, err := ctrl.service.SendEmailCodeConfirmation(emailAddr)
 	if err != nil {
+		if bindResendCooldown(c, err) {
+			return
+		}
 		utils.BindServiceErrorWithAbort(c, "SendEmailCodeConfirmationError", "Не удалось отправить сообщение на почту", err)
 		return
 	}
 
-	utils.BindNoContent(c)
+	c.JSON(http.StatusOK, result)
 }
 
 func (ctrl *RegisterController) RegisterByEmail(c *gin.Context) {
-	time.Sleep(time.Second)
-
 	var body struct {
 		Email    string `json:"email" binding:"required,email"`
 		Code     uint16 `json:"code" binding:"required"`
@@ -70,9 +81,12 @@ func (ctrl *RegisterController) RegisterByEmail(c *gin.Context) {
 		return
 	}
 
-	err = ctrl.service.RegisterByEmail(emailAddr, body.Code, body.Password)
+	err = ctrl.service.RegisterByEmail(emailAddr, body.Code, body.Password, c.ClientIP())
 	if err != nil {
-		if errors.Is(err, memcache.ErrCacheMiss) || errors.Is(err, register_service.ErrCodeDoesNotMatch) {
+		if bindTooManyAttempts(c, err) {
+			return
+		}
+		if errors.Is(err, register_service.ErrCodeDoesNotMatch) {
 			utils.BindErrorWithAbort(c, http.StatusForbidden, "ConfirmCodeError", "Неверный код подтверждения", err)
 			return
 		}
@@ -88,8 +102,6 @@ func (ctrl *RegisterController) RegisterByEmail(c *gin.Context) {
 }
 
 func (ctrl *RegisterController) RecoverSendEmailCodeConfirmation(c *gin.Context) {
-	time.Sleep(time.Second)
-
 	var body struct {
 		Email string `json:"email" binding:"required,email"`
 	}
@@ -105,22 +117,20 @@ func (ctrl *RegisterController) RecoverSendEmailCodeConfirmation(c *gin.Context)
 		return
 	}
 
-	err = ctrl.service.PasswordRecoverySendEmailCodeConfirmation(emailAddr)
+	result, err := ctrl.service.PasswordRecoverySendEmailCodeConfirmation(emailAddr)
 	if err != nil {
-		if errors.Is(err, register_service.ErrCodeHasAlreadyBeenSent) {
-			utils.BindErrorWithAbort(c, http.StatusTooManyRequests, "CodeHasAlreadyBeenSent", "Код подтверждения уже был отправлен", err)
+		if bindResendCooldown(c, err) {
+			return
 		} else {
 			utils.BindServiceErrorWithAbort(c, "RecoverSendEmailCodeError", "Непредвиденная ошибка при отправке код подтверждения", err)
 		}
 		return
 	}
 
-	utils.BindNoContent(c)
+	c.JSON(http.StatusOK, result)
 }
 
 func (ctrl *RegisterController) RecoverPassword(c *gin.Context) {
-	time.Sleep(time.Second * 3)
-
 	var body struct {
 		Email    string `json:"email" binding:"required,email"`
 		Code     uint16 `json:"code" binding:"required"`
@@ -138,9 +148,12 @@ func (ctrl *RegisterController) RecoverPassword(c *gin.Context) {
 		return
 	}
 
-	err = ctrl.service.PasswordRecovery(emailAddr, body.Code, body.Password)
+	err = ctrl.service.PasswordRecovery(emailAddr, body.Code, body.Password, c.ClientIP())
 	if err != nil {
-		if errors.Is(err, memcache.ErrCacheMiss) || errors.Is(err, register_service.ErrCodeDoesNotMatch) {
+		if bindTooManyAttempts(c, err) {
+			return
+		}
+		if errors.Is(err, register_service.ErrCodeDoesNotMatch) {
 			utils.BindErrorWithAbort(c, http.StatusForbidden, "ConfirmCodeError", "Неверный код подтверждения", err)
 			return
 		}
@@ -150,3 +163,119 @@ func (ctrl *RegisterController) RecoverPassword(c *gin.Context) {
 
 	utils.BindNoContent(c)
 }
+
+// CodeStatus состояние кода только для отправившего его: token - status_token из ответа на отправку кода
+func (ctrl *RegisterController) CodeStatus(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		utils.BindBadRequestWithAbort(c, "Параметр token обязателен", nil)
+		return
+	}
+
+	emailAddr, err := mail.ParseAddress(c.Query("email"))
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "Некорректный Email", err)
+		return
+	}
+
+	purpose, err := verification_service.ParsePurpose(c.DefaultQuery("purpose", string(verification_service.PurposeRegister)))
+	if err != nil || (purpose != verification_service.PurposeRegister && purpose != verification_service.PurposeRecover) {
+		utils.BindBadRequestWithAbort(c, "Некорректное назначение кода", err)
+		return
+	}
+
+	status, err := ctrl.service.CodeStatus(emailAddr, purpose, token)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "CodeStatusError", "Не удалось получить состояние кода подтверждения", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func (ctrl *RegisterController) ChangeEmailSendCode(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	var body struct {
+		NewEmail string `json:"new_email" binding:"required,email"`
+		Password string `json:"password" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "", err)
+		return
+	}
+
+	emailAddr, err := mail.ParseAddress(body.NewEmail)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "Некорректный Email", err)
+		return
+	}
+
+	err = ctrl.service.ChangeEmailSendCode(userID, emailAddr, body.Password, c.ClientIP())
+	if err != nil {
+		if bindTooManyAttempts(c, err) || bindResendCooldown(c, err) {
+			return
+		}
+		if errors.Is(err, register_service.ErrInvalidPassword) {
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "InvalidPassword", "Неверный пароль", err)
+			return
+		}
+		if errors.Is(err, register_service.ErrPasswordNotSet) {
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "PasswordNotSet", "Пароль не задан, задайте его через восстановление пароля", err)
+			return
+		}
+		if errors.Is(err, register_service.ErrSameEmail) {
+			utils.BindBadRequestWithAbort(c, "Новый Email совпадает с текущим", err)
+			return
+		}
+		if errors.Is(err, register_service.ErrLoginAlreadyExists) {
+			utils.BindErrorWithAbort(c, http.StatusConflict, "LoginAlreadyExists", "Такой логин уже существует", err)
+			return
+		}
+		utils.BindServiceErrorWithAbort(c, "ChangeEmailSendCodeError", "Не удалось отправить сообщение на почту", err)
+		return
+	}
+
+	utils.BindNoContent(c)
+}
+
+func (ctrl *RegisterController) ChangeEmail(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+	tokenUUID := c.MustGet("tokenUUID").(string)
+
+	var body struct {
+		NewEmail string `json:"new_email" binding:"required,email"`
+		Code     uint16 `json:"code" binding:"required"`
+	}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "", err)
+		return
+	}
+
+	emailAddr, err := mail.ParseAddress(body.NewEmail)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "Некорректный Email", err)
+		return
+	}
+
+	err = ctrl.service.ChangeEmail(userID, tokenUUID, emailAddr, body.Code, c.ClientIP())
+	if err != nil {
+		if bindTooManyAttempts(c, err) {
+			return
+		}
+		if errors.Is(err, register_service.ErrCodeDoesNotMatch) {
+			utils.BindErrorWithAbort(c, http.StatusForbidden, "ConfirmCodeError", "Неверный код подтверждения", err)
+			return
+		}
+		if errors.Is(err, register_service.ErrLoginAlreadyExists) {
+			utils.BindErrorWithAbort(c, http.StatusConflict, "LoginAlreadyExists", "Такой логин уже существует", err)
+			return
+		}
+		utils.BindServiceErrorWithAbort(c, "ChangeEmailError", "Ошибка изменения Email", err)
+		return
+	}
+
+	utils.BindNoContent(c)
+}
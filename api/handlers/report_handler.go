@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	report_service "odo24_mobile_backend/api/services/report"
+	"odo24_mobile_backend/api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReportController struct {
+	service *report_service.ReportService
+}
+
+func NewReportController(srv *report_service.ReportService) *ReportController {
+	return &ReportController{
+		service: srv,
+	}
+}
+
+func (ctrl *ReportController) CarServiceBook(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+	carID := c.MustGet("carID").(uint64)
+
+	// документ собирается целиком, чтобы при ошибке вернуть JSON, а не обрезанный файл
+	var buffer bytes.Buffer
+	err := ctrl.service.CarServiceBook(userID, carID, &buffer)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "ReportError", "Не удалось сформировать отчет", err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="service_book_%d.pdf"`, carID))
+	c.Data(http.StatusOK, "application/pdf", buffer.Bytes())
+}
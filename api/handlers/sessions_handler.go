@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	sessions_service "odo24_mobile_backend/api/services/sessions"
+	"odo24_mobile_backend/api/utils"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionsController struct {
+	service *sessions_service.SessionsService
+}
+
+func NewSessionsController(srv *sessions_service.SessionsService) *SessionsController {
+	return &SessionsController{
+		service: srv,
+	}
+}
+
+func (ctrl *SessionsController) GetSessionsByCurrentUser(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+	tokenUUID := c.MustGet("tokenUUID").(string)
+
+	sessions, err := ctrl.service.GetByUser(userID, tokenUUID)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "GetSessionsError", "Не удалось получить список сессий", err)
+		return
+	}
+
+	if len(sessions) == 0 {
+		utils.BindNoContent(c)
+	} else {
+		c.JSON(http.StatusOK, sessions)
+	}
+}
+
+func (ctrl *SessionsController) Delete(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	sessionID, err := strconv.ParseUint(c.Param("sessionID"), 10, 64)
+	if err != nil {
+		utils.BindBadRequestWithAbort(c, "Ошибка парсинга sessionID", err)
+		return
+	}
+
+	err = ctrl.service.Delete(userID, sessionID)
+	if err != nil {
+		if errors.Is(err, sessions_service.ErrSessionNotFound) {
+			utils.BindErrorWithAbort(c, http.StatusNotFound, "SessionNotFound", "Сессия не найдена", err)
+		} else {
+			utils.BindServiceErrorWithAbort(c, "SessionDeleteError", "Не удалось завершить сессию", err)
+		}
+		return
+	}
+
+	utils.BindNoContent(c)
+}
+
+func (ctrl *SessionsController) DeleteOthers(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+	tokenUUID := c.MustGet("tokenUUID").(string)
+
+	err := ctrl.service.DeleteOthers(userID, tokenUUID)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "SessionsDeleteError", "Не удалось завершить сессии", err)
+		return
+	}
+
+	utils.BindNoContent(c)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	statistics_service "odo24_mobile_backend/api/services/statistics"
+	"odo24_mobile_backend/api/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StatisticsController struct {
+	service *statistics_service.StatisticsService
+}
+
+func NewStatisticsController(srv *statistics_service.StatisticsService) *StatisticsController {
+	return &StatisticsController{
+		service: srv,
+	}
+}
+
+func (ctrl *StatisticsController) GetByCurrentUser(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+
+	stat, err := ctrl.service.GetByUser(userID)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "GetStatisticsError", "Не удалось получить статистику", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, stat)
+}
+
+func (ctrl *StatisticsController) GetByCar(c *gin.Context) {
+	userID := c.MustGet("userID").(uint64)
+	carID := c.MustGet("carID").(uint64)
+
+	stat, err := ctrl.service.GetByCar(userID, carID)
+	if err != nil {
+		utils.BindServiceErrorWithAbort(c, "GetStatisticsError", "Не удалось получить статистику", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, stat)
+}
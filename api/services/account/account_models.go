@@ -0,0 +1,8 @@
+package account_service
+
+import "time"
+
+type DeleteResultModel struct {
+	Deleted     bool       `json:"deleted"`
+	DeleteAfter *time.Time `json:"delete_after,omitempty"`
+}
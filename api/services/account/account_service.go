@@ -0,0 +1,266 @@
+package account_service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"odo24_mobile_backend/api/services"
+	sessions_service "odo24_mobile_backend/api/services/sessions"
+	verification_service "odo24_mobile_backend/api/services/verification"
+	"odo24_mobile_backend/api/utils"
+	"odo24_mobile_backend/db"
+	"odo24_mobile_backend/sendmail"
+	"strconv"
+	"time"
+)
+
+const purgeBatchSize = 100
+
+var (
+	ErrConfirmationRequired = errors.New("password or code required")
+	ErrInvalidPassword      = errors.New("invalid password")
+	ErrCodeDoesNotMatch     = verification_service.ErrCodeDoesNotMatch
+)
+
+type AccountService struct {
+	hasher       *utils.PasswordHasher
+	sessions     *sessions_service.SessionsService
+	guard        *services.BruteForceGuard
+	verification *verification_service.VerificationService
+	gracePeriod  time.Duration
+}
+
+/*
+NewAccountService управление аккаунтом пользователя.
+gracePeriod - отсрочка удаления аккаунта, 0 - данные удаляются сразу после подтверждения
+*/
+func NewAccountService(hasher *utils.PasswordHasher, sessions *sessions_service.SessionsService, guard *services.BruteForceGuard, verification *verification_service.VerificationService, gracePeriod time.Duration) *AccountService {
+	return &AccountService{
+		hasher:       hasher,
+		sessions:     sessions,
+		guard:        guard,
+		verification: verification,
+		gracePeriod:  gracePeriod,
+	}
+}
+
+// DeleteSendCode код подтверждения удаления на email пользователя, для аккаунтов без пароля (OAuth)
+func (srv *AccountService) DeleteSendCode(userID uint64) error {
+	pg := db.Conn()
+
+	var email string
+	err := pg.QueryRow("select u.login from profiles.users u where u.user_id=$1", userID).Scan(&email)
+	if err != nil {
+		return err
+	}
+
+	subject := strconv.FormatUint(userID, 10)
+	code, _, err := srv.verification.Issue(verification_service.PurposeAccountDelete, subject)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+	data["code"] = code
+
+	err = sendmail.Enqueue(userID, email, sendmail.TypeAccountDeleteCode, data)
+	if err != nil {
+		if discardErr := srv.verification.Discard(verification_service.PurposeAccountDelete, subject); discardErr != nil {
+			log.Printf("discard code error: %v", discardErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+/*
+Delete удаление аккаунта после подтверждения паролем или кодом из письма.
+При отсрочке все сессии завершаются, а данные удаляются после DeleteAfter, если пользователь не войдет снова
+*/
+func (srv *AccountService) Delete(userID uint64, password, code string, ip string) (*DeleteResultModel, error) {
+	guardKey := fmt.Sprintf("account_delete.%d", userID)
+	err := srv.guard.Check(guardKey, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv.confirm(userID, password, code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidPassword) || errors.Is(err, ErrCodeDoesNotMatch) {
+			if _, failErr := srv.guard.Fail(guardKey, ip); failErr != nil {
+				log.Printf("fail attempt error: %v", failErr)
+			}
+		}
+		return nil, err
+	}
+
+	if err := srv.guard.Reset(guardKey); err != nil {
+		log.Printf("reset attempts error: %v", err)
+	}
+
+	if srv.gracePeriod <= 0 {
+		err = srv.purge(userID, false)
+		if err != nil {
+			return nil, err
+		}
+		return &DeleteResultModel{Deleted: true}, nil
+	}
+
+	deleteAfter := time.Now().Add(srv.gracePeriod)
+
+	pg := db.Conn()
+	_, err = pg.Exec("update profiles.users set delete_after=$1 where user_id=$2", deleteAfter, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv.sessions.DeleteAll(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeleteResultModel{DeleteAfter: &deleteAfter}, nil
+}
+
+func (srv *AccountService) confirm(userID uint64, password, code string) error {
+	if code != "" {
+		return srv.verification.Verify(verification_service.PurposeAccountDelete, strconv.FormatUint(userID, 10), code)
+	}
+	if password == "" {
+		return ErrConfirmationRequired
+	}
+
+	pg := db.Conn()
+	var passwordHash []byte
+	var salt []byte
+	err := pg.QueryRow("select u.password_hash,u.salt from profiles.users u where u.user_id=$1", userID).Scan(&passwordHash, &salt)
+	if err != nil {
+		return err
+	}
+
+	ok, _, err := srv.hasher.Verify(password, passwordHash, salt)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+/*
+purge удаление пользователя и всех его данных одной транзакцией.
+Записи сервисной книжки удаляются явно, остальные таблицы profiles - каскадом от profiles.users.
+scheduled - удаление по истечении отсрочки, пропускается если пользователь успел войти и отменить его
+*/
+func (srv *AccountService) purge(userID uint64, scheduled bool) error {
+	pg := db.Conn()
+
+	tx, err := pg.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if scheduled {
+		var lockedUserID uint64
+		err = tx.QueryRow("SELECT u.user_id FROM profiles.users u WHERE u.user_id=$1 AND u.delete_after<=now() FOR UPDATE", userID).Scan(&lockedUserID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	tokenUUIDs, err := srv.sessions.DeleteAllTx(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM service_book.services s
+	WHERE s.car_id IN (SELECT c.car_id FROM service_book.car c WHERE c.user_id=$1)
+	OR s.group_id IN (SELECT g.group_id FROM service_book.service_groups g WHERE g.user_id=$1)`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM service_book.car WHERE user_id=$1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM service_book.service_groups WHERE user_id=$1`, userID)
+	if err != nil {
+		return err
+	}
+
+	// письма без владельца (восстановление пароля) на адрес пользователя, с владельцем удалятся каскадом
+	_, err = tx.Exec(`DELETE FROM profiles.email_outbox o USING profiles.users u WHERE u.user_id=$1 AND o.recipient=u.login`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM profiles.users WHERE user_id=$1`, userID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	srv.sessions.RevokeAccessTokens(tokenUUIDs)
+	log.Printf("user_id=%d account deleted", userID)
+	return nil
+}
+
+// PurgeExpired удаление аккаунтов, у которых истекла отсрочка
+func (srv *AccountService) PurgeExpired() error {
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT u.user_id FROM profiles.users u WHERE u.delete_after<=now() ORDER BY u.delete_after LIMIT $1`, purgeBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var userIDs []uint64
+	for rows.Next() {
+		var userID uint64
+		err := rows.Scan(&userID)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		err := srv.purge(userID, true)
+		if err != nil {
+			log.Printf("purge user_id=%d error: %v", userID, err)
+		}
+	}
+
+	return nil
+}
+
+// RunPurge периодический запуск PurgeExpired, блокирует вызывающую горутину
+func (srv *AccountService) RunPurge(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := srv.PurgeExpired()
+		if err != nil {
+			log.Printf("purge expired accounts error: %v", err)
+		}
+	}
+}
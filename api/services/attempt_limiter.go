@@ -0,0 +1,336 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	AttemptsStoreMemcache = "memcache"
+	AttemptsStoreMemory   = "memory"
+
+	attemptsKeyPrefix = "attempts."
+	maxDelayShift     = 20
+	// maxCASRetries повторы обновления счетчика при одновременных ошибках
+	maxCASRetries = 10
+)
+
+var (
+	ErrorTooManyAttempts = errors.New("too many attempts")
+	ErrorAttemptConflict = errors.New("attempt state update conflict")
+)
+
+// TooManyAttemptsError превышено число попыток, повторить можно через RetryAfter
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("too many attempts, retry after %s", e.RetryAfter)
+}
+
+func (e *TooManyAttemptsError) Is(target error) bool {
+	return target == ErrorTooManyAttempts
+}
+
+// AttemptState счетчик неудачных попыток по ключу
+type AttemptState struct {
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+/*
+AttemptStore хранилище счетчиков, отсутствие ключа - nil без ошибки.
+Update атомарно изменяет состояние (для отсутствующего ключа - пустое), чтобы параллельные ошибки не терялись
+*/
+type AttemptStore interface {
+	Get(key string) (*AttemptState, error)
+	Update(key string, ttl time.Duration, update func(state *AttemptState)) (*AttemptState, error)
+	Delete(key string) error
+}
+
+// LimiterOptions первые FreeAttempts ошибок без задержки, дальше задержка удваивается от BaseDelay до MaxDelay
+type LimiterOptions struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// StateTTL через сколько после последней ошибки счетчик сбрасывается
+	StateTTL time.Duration
+}
+
+// AttemptLimiter защита от перебора с экспоненциальной задержкой и блокировкой
+type AttemptLimiter struct {
+	store   AttemptStore
+	options LimiterOptions
+}
+
+func NewAttemptLimiter(store AttemptStore, options LimiterOptions) *AttemptLimiter {
+	return &AttemptLimiter{
+		store:   store,
+		options: options,
+	}
+}
+
+// Check ошибка TooManyAttemptsError, если хотя бы один из ключей заблокирован
+func (l *AttemptLimiter) Check(keys ...string) error {
+	now := time.Now()
+
+	var retryAfter time.Duration
+	for _, key := range keys {
+		state, err := l.store.Get(key)
+		if err != nil {
+			return err
+		}
+		if state != nil && state.LockedUntil.After(now) {
+			if wait := state.LockedUntil.Sub(now); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+	}
+
+	if retryAfter > 0 {
+		return &TooManyAttemptsError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// Fail учет неудачной попытки, возвращает число ошибок подряд
+func (l *AttemptLimiter) Fail(key string) (int, error) {
+	state, err := l.store.Update(key, l.options.StateTTL, func(state *AttemptState) {
+		state.Failures++
+		if state.Failures >= l.options.FreeAttempts {
+			delay := l.options.MaxDelay
+			if exp := state.Failures - l.options.FreeAttempts; exp < maxDelayShift {
+				if d := l.options.BaseDelay << exp; d < delay {
+					delay = d
+				}
+			}
+			state.LockedUntil = time.Now().Add(delay)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return state.Failures, nil
+}
+
+// Reset сброс счетчиков после успешной попытки
+func (l *AttemptLimiter) Reset(keys ...string) error {
+	for _, key := range keys {
+		err := l.store.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewAttemptStore хранилище по имени из настроек, по умолчанию memcache
+func NewAttemptStore(store string, memc *memcache.Client) AttemptStore {
+	if store == AttemptsStoreMemory {
+		return NewMemoryAttemptStore()
+	}
+	return NewMemcacheAttemptStore(memc)
+}
+
+type MemcacheAttemptStore struct {
+	memc *memcache.Client
+}
+
+func NewMemcacheAttemptStore(memc *memcache.Client) *MemcacheAttemptStore {
+	return &MemcacheAttemptStore{
+		memc: memc,
+	}
+}
+
+// attemptsKey ключ memcache из хеша: email в ключе аккаунта может быть длиннее 250 байт, допустимых в memcache
+func attemptsKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return attemptsKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+func (s *MemcacheAttemptStore) Get(key string) (*AttemptState, error) {
+	item, err := s.memc.Get(attemptsKey(key))
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var state AttemptState
+	err = json.Unmarshal(item.Value, &state)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Update через Add для нового ключа и CompareAndSwap для существующего, при конфликте - повтор
+func (s *MemcacheAttemptStore) Update(key string, ttl time.Duration, update func(state *AttemptState)) (*AttemptState, error) {
+	for i := 0; i < maxCASRetries; i++ {
+		item, err := s.memc.Get(attemptsKey(key))
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return nil, err
+		}
+
+		var state AttemptState
+		if item != nil {
+			err = json.Unmarshal(item.Value, &state)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		update(&state)
+
+		value, err := json.Marshal(state)
+		if err != nil {
+			return nil, err
+		}
+
+		if item == nil {
+			err = s.memc.Add(&memcache.Item{
+				Key:        attemptsKey(key),
+				Value:      value,
+				Expiration: int32(ttl.Seconds()),
+			})
+		} else {
+			item.Value = value
+			item.Expiration = int32(ttl.Seconds())
+			err = s.memc.CompareAndSwap(item)
+		}
+
+		if err == nil {
+			return &state, nil
+		}
+		if !errors.Is(err, memcache.ErrNotStored) && !errors.Is(err, memcache.ErrCASConflict) && !errors.Is(err, memcache.ErrCacheMiss) {
+			return nil, err
+		}
+	}
+
+	return nil, ErrorAttemptConflict
+}
+
+func (s *MemcacheAttemptStore) Delete(key string) error {
+	err := s.memc.Delete(attemptsKey(key))
+	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return err
+	}
+	return nil
+}
+
+// MemoryAttemptStore хранилище в памяти процесса, для тестов и запуска без memcached
+type MemoryAttemptStore struct {
+	mx    sync.Mutex
+	items map[string]memoryAttemptItem
+}
+
+type memoryAttemptItem struct {
+	state      AttemptState
+	expiration time.Time
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		items: make(map[string]memoryAttemptItem),
+	}
+}
+
+func (s *MemoryAttemptStore) Get(key string) (*AttemptState, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	item, ok := s.items[key]
+	if !ok || time.Now().After(item.expiration) {
+		return nil, nil
+	}
+	state := item.state
+	return &state, nil
+}
+
+func (s *MemoryAttemptStore) Update(key string, ttl time.Duration, update func(state *AttemptState)) (*AttemptState, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := time.Now()
+	for k, item := range s.items {
+		if now.After(item.expiration) {
+			delete(s.items, k)
+		}
+	}
+
+	var state AttemptState
+	if item, ok := s.items[key]; ok {
+		state = item.state
+	}
+
+	update(&state)
+
+	s.items[key] = memoryAttemptItem{
+		state:      state,
+		expiration: now.Add(ttl),
+	}
+	return &state, nil
+}
+
+func (s *MemoryAttemptStore) Delete(key string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	delete(s.items, key)
+	return nil
+}
+
+// BruteForceGuard лимиты неудачных попыток по аккаунту (email, пользователь) и по IP адресу
+type BruteForceGuard struct {
+	Account *AttemptLimiter
+	IP      *AttemptLimiter
+}
+
+// NewBruteForceGuard по IP допускается больше ошибок, за одним адресом может быть много пользователей
+func NewBruteForceGuard(store AttemptStore) *BruteForceGuard {
+	return &BruteForceGuard{
+		Account: NewAttemptLimiter(store, LimiterOptions{
+			FreeAttempts: 5,
+			BaseDelay:    time.Second * 30,
+			MaxDelay:     time.Hour,
+			StateTTL:     time.Hour * 24,
+		}),
+		IP: NewAttemptLimiter(store, LimiterOptions{
+			FreeAttempts: 20,
+			BaseDelay:    time.Second * 30,
+			MaxDelay:     time.Hour,
+			StateTTL:     time.Hour * 24,
+		}),
+	}
+}
+
+func (g *BruteForceGuard) Check(accountKey, ip string) error {
+	err := g.Account.Check(accountKey)
+	if err != nil {
+		return err
+	}
+	return g.IP.Check("ip." + ip)
+}
+
+// Fail возвращает число ошибок подряд по аккаунту
+func (g *BruteForceGuard) Fail(accountKey, ip string) (int, error) {
+	_, err := g.IP.Fail("ip." + ip)
+	if err != nil {
+		return 0, err
+	}
+	return g.Account.Fail(accountKey)
+}
+
+func (g *BruteForceGuard) Reset(accountKey string) error {
+	return g.Account.Reset(accountKey)
+}
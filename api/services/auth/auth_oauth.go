@@ -0,0 +1,81 @@
+package auth_service
+
+import (
+	"database/sql"
+	"errors"
+	oauth_service "odo24_mobile_backend/api/services/oauth"
+	sessions_service "odo24_mobile_backend/api/services/sessions"
+	"odo24_mobile_backend/db"
+)
+
+/*
+LoginByOAuth авторизация через внешнего провайдера.
+Аккаунт провайдера привязывается к пользователю с тем же email, либо создается новый пользователь.
+Привязка и создание только для адреса, подтвержденного провайдером, иначе email мог указать кто угодно
+*/
+func (srv *AuthService) LoginByOAuth(provider oauth_service.Provider, code string, client sessions_service.ClientInfo) (*AuthResultModel, error) {
+	accessToken, err := provider.ExchangeCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := provider.GetUserInfo(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := srv.findOrCreateOAuthUser(provider.Name(), info)
+	if err != nil {
+		return nil, err
+	}
+
+	return srv.completeLogin(userID, client)
+}
+
+func (srv *AuthService) findOrCreateOAuthUser(providerName string, info *oauth_service.UserInfoModel) (uint64, error) {
+	if info.ID == "" {
+		return 0, oauth_service.ErrIDNotProvided
+	}
+
+	pg := db.Conn()
+
+	var userID uint64
+	err := pg.QueryRow("select a.user_id from profiles.oauth_accounts a where a.provider=$1 and a.external_id=$2", providerName, info.ID).Scan(&userID)
+	if err == nil {
+		return userID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	if !info.EmailVerified {
+		return 0, oauth_service.ErrEmailNotVerified
+	}
+
+	tx, err := pg.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// логин при регистрации по email сохраняется в том регистре, как его ввели, а email провайдера приведен к нижнему
+	err = tx.QueryRow("select u.user_id from profiles.users u where lower(u.login)=lower($1) order by u.user_id limit 1", info.Email).Scan(&userID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+
+		// пароля у такого пользователя нет, вход только через провайдера или после восстановления пароля
+		err = tx.QueryRow(`INSERT INTO profiles.users (login,password_hash,oauth,last_login_dt,salt) VALUES($1,$2,$3,now()::timestamp without time zone,$4) RETURNING user_id`, info.Email, []byte{}, true, []byte{}).Scan(&userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO profiles.oauth_accounts (provider,external_id,user_id,email) VALUES($1,$2,$3,$4)", providerName, info.ID, userID, info.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
@@ -1,62 +1,66 @@
 package auth_service
 
 import (
-	"bytes"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
 	"odo24_mobile_backend/api/services"
+	sessions_service "odo24_mobile_backend/api/services/sessions"
 	"odo24_mobile_backend/api/utils"
 	"odo24_mobile_backend/db"
-	"os"
+	"strings"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+var ErrInvalidPassword = errors.New("invalid password")
+
 const (
-	defaultAccessTokenExp  = time.Minute * 20
-	defaultRefreshTokenExp = time.Hour * 24 * 30 * 6
+	DefaultAccessTokenExp  = time.Minute * 20
+	DefaultRefreshTokenExp = time.Hour * 24 * 30 * 6
 )
 
 type AuthService struct {
-	jwtAccessPrivateKey  []byte
-	jwtAccessPublicKey   []byte
-	jwtRefreshPrivateKey []byte
-	jwtRefreshPublicKey  []byte
+	hasher      *utils.PasswordHasher
+	sessions    *sessions_service.SessionsService
+	accessKeys  *KeyRing
+	refreshKeys *KeyRing
+	guard       *services.BruteForceGuard
 }
 
-func NewAuthService(jwtAccessPrivateKeyPath, jwtAccessPublicKeyPath, jwtRefreshPrivateKeyPath, jwtRefreshPublicKeyPath string) *AuthService {
-	// jwt access
-	accessPrivateKey, err := os.ReadFile(jwtAccessPrivateKeyPath)
-	if err != nil {
-		panic(err)
-	}
-	accessPublicKey, err := os.ReadFile(jwtAccessPublicKeyPath)
-	if err != nil {
-		panic(err)
+func NewAuthService(hasher *utils.PasswordHasher, sessions *sessions_service.SessionsService, accessKeys, refreshKeys *KeyRing, guard *services.BruteForceGuard) *AuthService {
+	return &AuthService{
+		hasher:      hasher,
+		sessions:    sessions,
+		accessKeys:  accessKeys,
+		refreshKeys: refreshKeys,
+		guard:       guard,
 	}
-	// jwt refresh
-	refreshPrivateKey, err := os.ReadFile(jwtRefreshPrivateKeyPath)
+}
+
+func (srv *AuthService) Login(email string, password string, client sessions_service.ClientInfo) (*AuthResultModel, error) {
+	guardKey := "login." + strings.ToLower(email)
+	err := srv.checkAttempts(guardKey, client.IP)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	refreshPublicKey, err := os.ReadFile(jwtRefreshPublicKeyPath)
+
+	userID, err := srv.checkPassword(email, password)
 	if err != nil {
-		panic(err)
+		if errors.Is(err, services.ErrorUnauthorize) {
+			srv.failAttempt(guardKey, client.IP)
+		}
+		return nil, err
 	}
 
-	return &AuthService{
-		jwtAccessPrivateKey:  accessPrivateKey,
-		jwtAccessPublicKey:   accessPublicKey,
-		jwtRefreshPrivateKey: refreshPrivateKey,
-		jwtRefreshPublicKey:  refreshPublicKey,
-	}
+	srv.resetAttempts(guardKey)
+	return srv.completeLogin(userID, client)
 }
 
-func (srv *AuthService) Login(email string, password string) (*AuthResultModel, error) {
+func (srv *AuthService) checkPassword(email string, password string) (uint64, error) {
 	pg := db.Conn()
 	var user struct {
 		UserID   uint64
@@ -66,87 +70,132 @@ func (srv *AuthService) Login(email string, password string) (*AuthResultModel,
 	err := pg.QueryRow("select u.user_id,u.password_hash,u.salt from profiles.users u where u.login=$1", email).Scan(&user.UserID, &user.Password, &user.Salt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, services.ErrorUnauthorize
+			return 0, services.ErrorUnauthorize
 		}
-		return nil, err
+		return 0, err
 	}
 
 	if user.UserID == 0 {
-		return nil, services.ErrorUnauthorize
+		return 0, services.ErrorUnauthorize
 	}
 
-	currentPassword, err := utils.GetPasswordHash([]byte(password), user.Salt)
+	ok, needRehash, err := srv.hasher.Verify(password, user.Password, user.Salt)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	if !bytes.Equal(currentPassword, user.Password) {
-		return nil, services.ErrorUnauthorize
+	if !ok {
+		return 0, services.ErrorUnauthorize
 	}
 
-	tokens, tokenUUID, err := srv.tokenGenerate(user.UserID)
-	if err != nil {
-		return nil, err
+	if needRehash {
+		srv.rehashPassword(user.UserID, password)
 	}
 
-	_, err = pg.Exec("update profiles.users set token_uuid=$1,last_login_dt=now() where user_id=$2", tokenUUID, user.UserID)
+	return user.UserID, nil
+}
+
+// checkAttempts без хранилища счетчиков перебор не ограничить, поэтому его ошибка тоже прерывает вход
+func (srv *AuthService) checkAttempts(guardKey, ip string) error {
+	return srv.guard.Check(guardKey, ip)
+}
+
+func (srv *AuthService) failAttempt(guardKey, ip string) {
+	_, err := srv.guard.Fail(guardKey, ip)
 	if err != nil {
-		return nil, err
+		log.Printf("fail attempt error: %v", err)
 	}
+}
 
-	return tokens, nil
+func (srv *AuthService) resetAttempts(guardKey string) {
+	err := srv.guard.Reset(guardKey)
+	if err != nil {
+		log.Printf("reset attempts error: %v", err)
+	}
 }
 
-func (srv *AuthService) ChangePassword(userID uint64, oldPassword, newPassword string) error {
-	pg := db.Conn()
-	var currentPassword []byte
-	var currentSalt []byte
-	err := pg.QueryRow("select u.password_hash,u.salt from profiles.users u where u.user_id=$1", userID).Scan(&currentPassword, &currentSalt)
+// issueTokens выпуск пары токенов и создание сессии устройства после успешной авторизации
+func (srv *AuthService) issueTokens(userID uint64, client sessions_service.ClientInfo) (*AuthResultModel, error) {
+	tokens, tokenUUID, err := srv.tokenGenerate(userID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	oldHashPassword, err := utils.GetPasswordHash([]byte(oldPassword), currentSalt)
+	err = srv.sessions.Create(userID, tokenUUID, client)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	currentHashPassword, err := utils.GetPasswordHash(currentPassword, currentSalt)
+	pg := db.Conn()
+	// вход в период отсрочки отменяет запрошенное удаление аккаунта
+	_, err = pg.Exec("update profiles.users set last_login_dt=now(),delete_after=null where user_id=$1", userID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// rehashPassword перевод хеша пароля на актуальный алгоритм, ошибка не прерывает авторизацию
+func (srv *AuthService) rehashPassword(userID uint64, password string) {
+	newHashPassword, err := srv.hasher.Hash(password)
+	if err != nil {
+		log.Printf("rehash password error: %v", err)
+		return
 	}
 
-	if !bytes.Equal(oldHashPassword, currentHashPassword) {
-		return errors.New("invalid password")
+	pg := db.Conn()
+	_, err = pg.Exec("update profiles.users set password_hash=$1,salt=$2 where user_id=$3", newHashPassword, []byte{}, userID)
+	if err != nil {
+		log.Printf("rehash password error: %v", err)
 	}
+}
 
-	salt, err := utils.GenerateSalt()
+// ChangePassword смена пароля, остальные сессии пользователя завершаются
+func (srv *AuthService) ChangePassword(userID uint64, tokenUUID string, oldPassword, newPassword string) error {
+	pg := db.Conn()
+	var currentPassword []byte
+	var currentSalt []byte
+	err := pg.QueryRow("select u.password_hash,u.salt from profiles.users u where u.user_id=$1", userID).Scan(&currentPassword, &currentSalt)
 	if err != nil {
 		return err
 	}
 
-	newHashPassword, err := utils.GetPasswordHash([]byte(newPassword), salt)
+	ok, _, err := srv.hasher.Verify(oldPassword, currentPassword, currentSalt)
 	if err != nil {
 		return err
 	}
+	if !ok {
+		return ErrInvalidPassword
+	}
 
-	newSalt, err := utils.GenerateSalt()
+	newHashPassword, err := srv.hasher.Hash(newPassword)
 	if err != nil {
 		return err
 	}
 
-	_, err = pg.Exec("update profiles.users set password_hash=$1,salt=$2 where user_id=$3", newHashPassword, newSalt, userID)
+	_, err = pg.Exec("update profiles.users set password_hash=$1,salt=$2 where user_id=$3", newHashPassword, []byte{}, userID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return srv.sessions.DeleteOthers(userID, tokenUUID)
+}
+
+// Logout завершение текущей сессии
+func (srv *AuthService) Logout(userID uint64, tokenUUID string) error {
+	return srv.sessions.DeleteByTokenUUID(userID, tokenUUID)
+}
+
+// LogoutAll завершение всех сессий пользователя, включая текущую
+func (srv *AuthService) LogoutAll(userID uint64) error {
+	return srv.sessions.DeleteAll(userID)
 }
 
 /*
 RefreshToken рефреш токена
 */
-func (srv *AuthService) RefreshToken(accessTokenStr, refreshTokenStr string) (*AuthResultModel, error) {
+func (srv *AuthService) RefreshToken(accessTokenStr, refreshTokenStr string, client sessions_service.ClientInfo) (*AuthResultModel, error) {
 	accessToken, err := srv.ParseAccessToken(accessTokenStr, jwt.WithoutClaimsValidation())
 	if err != nil {
 		return nil, err
@@ -179,89 +228,87 @@ func (srv *AuthService) RefreshToken(accessTokenStr, refreshTokenStr string) (*A
 
 	userID := uint64(accessClaims["uid"].(float64))
 
-	pg := db.Conn()
-	var dbRefreshUUID string
-	err = pg.QueryRow("select u.token_uuid from profiles.users u where u.user_id=$1", userID).Scan(&dbRefreshUUID)
+	tokens, tokenUUID, err := srv.tokenGenerate(userID)
 	if err != nil {
 		return nil, err
 	}
 
-	if dbRefreshUUID != refreshUUID {
-		return nil, services.ErrorUnauthorize
-	}
-
-	tokens, tokenUUID, err := srv.tokenGenerate(userID)
+	err = srv.sessions.Rotate(userID, refreshUUID, tokenUUID, client)
 	if err != nil {
+		if errors.Is(err, sessions_service.ErrSessionNotFound) || errors.Is(err, sessions_service.ErrRefreshTokenReused) {
+			return nil, fmt.Errorf("%w: %w", services.ErrorUnauthorize, err)
+		}
 		return nil, err
 	}
 
-	_, err = pg.Exec("update profiles.users set token_uuid=$1 where user_id=$2", tokenUUID, userID)
-	return tokens, err
+	return tokens, nil
+}
+
+// IsAccessTokenRevoked access токен отозван до истечения срока
+func (srv *AuthService) IsAccessTokenRevoked(tokenUUID string) (bool, error) {
+	return srv.sessions.IsAccessTokenRevoked(tokenUUID)
 }
 
 func (srv *AuthService) ParseAccessToken(tokenString string, options ...jwt.ParserOption) (*jwt.Token, error) {
-	return srv.parseToken(tokenString, srv.jwtAccessPublicKey, options...)
-}
+	token, err := jwt.Parse(tokenString, srv.accessKeys.Keyfunc, options...)
+	if err != nil {
+		return nil, err
+	}
 
-func (srv *AuthService) ParseRefreshToken(tokenString string, options ...jwt.ParserOption) (*jwt.Token, error) {
-	return srv.parseToken(tokenString, srv.jwtRefreshPublicKey, options...)
+	// токены с typ (challenge 2FA) не дают доступа к апи, даже если ключ совпал
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && claims["typ"] != nil {
+		return nil, ErrInvalidTokenType
+	}
+
+	return token, nil
 }
 
-func (srv *AuthService) parseToken(tokenString string, pubKey []byte, options ...jwt.ParserOption) (*jwt.Token, error) {
-	key, err := jwt.ParseRSAPublicKeyFromPEM(pubKey)
+func (srv *AuthService) ParseRefreshToken(tokenString string, options ...jwt.ParserOption) (*jwt.Token, error) {
+	token, err := jwt.Parse(tokenString, srv.refreshKeys.Keyfunc, options...)
 	if err != nil {
-		log.Printf("error parsing RSA public key: %v\n", err)
-		return nil, services.ErrorParsingRSAPublicKey
+		return nil, err
 	}
 
-	parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return key, nil
-	}, options...)
-	return parsedToken, err
+	// challenge токен 2FA подписан ключом refresh токенов, но не является refresh токеном
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && claims["typ"] != nil {
+		return nil, ErrInvalidTokenType
+	}
+
+	return token, nil
+}
+
+// AccessJWKS публичные ключи access токенов для проверки другими сервисами
+func (srv *AuthService) AccessJWKS() JWKSModel {
+	return srv.accessKeys.JWKS()
 }
 
 func (srv *AuthService) tokenGenerate(userID uint64) (*AuthResultModel, string, error) {
 	tokenUUID := uuid.New().String()
 
 	// access
-	accessKey, err := jwt.ParseRSAPrivateKeyFromPEM(srv.jwtAccessPrivateKey)
-	if err != nil {
-		log.Printf("error parsing RSA private key: %v\n", err)
-		return nil, "", services.ErrorParsingRSAPrivateKey
-	}
-
 	accessToken := jwt.New(jwt.SigningMethodRS256)
 
-	accessTokenExp := time.Now().Add(defaultAccessTokenExp).Unix()
+	accessTokenExp := time.Now().Add(DefaultAccessTokenExp).Unix()
 	accessClaims := accessToken.Claims.(jwt.MapClaims)
 	accessClaims["exp"] = accessTokenExp
 	accessClaims["uid"] = userID
 	accessClaims["uuid"] = tokenUUID
 
-	accessTokenString, err := accessToken.SignedString(accessKey)
+	accessTokenString, err := srv.accessKeys.Sign(accessToken)
 	if err != nil {
 		log.Printf("error signing token: %v\n", err)
 		return nil, tokenUUID, services.ErrorSigningJwtToken
 	}
 
 	// refresh
-	refreshKey, err := jwt.ParseRSAPrivateKeyFromPEM(srv.jwtRefreshPrivateKey)
-	if err != nil {
-		log.Printf("error parsing RSA private key: %v\n", err)
-		return nil, "", services.ErrorParsingRSAPrivateKey
-	}
-
 	refreshToken := jwt.New(jwt.SigningMethodRS256)
 
-	refreshTokenExp := time.Now().Add(defaultRefreshTokenExp).Unix()
+	refreshTokenExp := time.Now().Add(DefaultRefreshTokenExp).Unix()
 	refreshClaims := refreshToken.Claims.(jwt.MapClaims)
 	refreshClaims["exp"] = refreshTokenExp
 	refreshClaims["uuid"] = tokenUUID
 
-	refreshTokenString, err := refreshToken.SignedString(refreshKey)
+	refreshTokenString, err := srv.refreshKeys.Sign(refreshToken)
 	if err != nil {
 		log.Printf("error signing token: %v\n", err)
 		return nil, tokenUUID, services.ErrorSigningJwtToken
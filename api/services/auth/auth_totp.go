@@ -0,0 +1,340 @@
+package auth_service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log"
+	sessions_service "odo24_mobile_backend/api/services/sessions"
+	"odo24_mobile_backend/api/utils"
+	"odo24_mobile_backend/db"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	totpIssuer             = "odo24.ru"
+	challengeTokenType     = "2fa"
+	challengeAudience      = "odo24-2fa-challenge"
+	defaultChallengeExp    = time.Minute * 5
+	recoveryCodesCount     = 10
+	recoveryCodeSize       = 5
+	recoveryCodeHalfLength = 4
+)
+
+var (
+	ErrTOTPAlreadyEnabled = errors.New("totp already enabled")
+	ErrTOTPNotEnrolled    = errors.New("totp not enrolled")
+	ErrTOTPNotEnabled     = errors.New("totp not enabled")
+	ErrInvalidTOTPCode    = errors.New("invalid totp code")
+	ErrInvalidTokenType   = errors.New("invalid token type")
+	// ErrInvalidChallengeToken challenge токен не разобран или не прошел проверку: подпись, срок, аудитория, kid
+	ErrInvalidChallengeToken = errors.New("invalid challenge token")
+)
+
+// TwoFactorRequiredError пароль верный, для выдачи токенов нужен код второго фактора
+type TwoFactorRequiredError struct {
+	Challenge TwoFactorChallengeModel
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	return "two factor authentication required"
+}
+
+// completeLogin выдача токенов, либо challenge токена если включена 2FA
+func (srv *AuthService) completeLogin(userID uint64, client sessions_service.ClientInfo) (*AuthResultModel, error) {
+	pg := db.Conn()
+
+	var totpEnabled bool
+	err := pg.QueryRow("select u.totp_enabled from profiles.users u where u.user_id=$1", userID).Scan(&totpEnabled)
+	if err != nil {
+		return nil, err
+	}
+
+	if !totpEnabled {
+		return srv.issueTokens(userID, client)
+	}
+
+	// ключи refresh токенов не публикуются в JWKS, другие сервисы не примут challenge токен за access
+	challengeToken := jwt.New(jwt.SigningMethodRS256)
+	claims := challengeToken.Claims.(jwt.MapClaims)
+	claims["exp"] = time.Now().Add(defaultChallengeExp).Unix()
+	claims["uid"] = userID
+	claims["typ"] = challengeTokenType
+	claims["aud"] = challengeAudience
+
+	challengeTokenString, err := srv.refreshKeys.Sign(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, &TwoFactorRequiredError{
+		Challenge: TwoFactorChallengeModel{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeTokenString,
+			ExpiresIn:         int(defaultChallengeExp.Seconds()),
+		},
+	}
+}
+
+// LoginTwoFactor обмен challenge токена и кода TOTP (или кода восстановления) на пару токенов
+func (srv *AuthService) LoginTwoFactor(challengeTokenStr, code string, client sessions_service.ClientInfo) (*AuthResultModel, error) {
+	token, err := jwt.Parse(challengeTokenStr, srv.refreshKeys.Keyfunc, jwt.WithAudience(challengeAudience))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidChallengeToken, err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != challengeTokenType {
+		return nil, ErrInvalidTokenType
+	}
+
+	uid, ok := claims["uid"].(float64)
+	if !ok {
+		return nil, ErrInvalidTokenType
+	}
+	userID := uint64(uid)
+
+	guardKey := fmt.Sprintf("2fa.%d", userID)
+	err = srv.checkAttempts(guardKey, client.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv.verifySecondFactor(userID, code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			srv.failAttempt(guardKey, client.IP)
+		}
+		return nil, err
+	}
+
+	srv.resetAttempts(guardKey)
+	return srv.issueTokens(userID, client)
+}
+
+// TOTPEnroll новый секрет TOTP, включается только после подтверждения кодом
+func (srv *AuthService) TOTPEnroll(userID uint64) (*TOTPEnrollModel, error) {
+	pg := db.Conn()
+
+	var login string
+	var totpEnabled bool
+	err := pg.QueryRow("select u.login,u.totp_enabled from profiles.users u where u.user_id=$1", userID).Scan(&login, &totpEnabled)
+	if err != nil {
+		return nil, err
+	}
+	if totpEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = pg.Exec("update profiles.users set totp_secret=$1,totp_last_step=0 where user_id=$2", secret, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollModel{
+		Secret: secret,
+		URI:    utils.TOTPURI(totpIssuer, login, secret),
+	}, nil
+}
+
+// TOTPConfirm включение 2FA первым кодом из приложения, возвращает коды восстановления
+func (srv *AuthService) TOTPConfirm(userID uint64, code, ip string) (*RecoveryCodesModel, error) {
+	guardKey := fmt.Sprintf("totp_manage.%d", userID)
+	err := srv.checkAttempts(guardKey, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	pg := db.Conn()
+
+	var secret sql.NullString
+	var totpEnabled bool
+	err = pg.QueryRow("select u.totp_secret,u.totp_enabled from profiles.users u where u.user_id=$1", userID).Scan(&secret, &totpEnabled)
+	if err != nil {
+		return nil, err
+	}
+	if totpEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if !secret.Valid || secret.String == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	ok, step, err := utils.ValidateTOTP(secret.String, code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		srv.failAttempt(guardKey, ip)
+		return nil, ErrInvalidTOTPCode
+	}
+	srv.resetAttempts(guardKey)
+
+	tx, err := pg.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("update profiles.users set totp_enabled=true,totp_last_step=$1 where user_id=$2", step, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes, err := srv.replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RecoveryCodesModel{
+		RecoveryCodes: codes,
+	}, nil
+}
+
+// TOTPDisable отключение 2FA, требуется действующий код
+func (srv *AuthService) TOTPDisable(userID uint64, code, ip string) error {
+	guardKey := fmt.Sprintf("totp_manage.%d", userID)
+	err := srv.checkAttempts(guardKey, ip)
+	if err != nil {
+		return err
+	}
+
+	err = srv.verifySecondFactor(userID, code)
+	if err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			srv.failAttempt(guardKey, ip)
+		}
+		return err
+	}
+	srv.resetAttempts(guardKey)
+
+	pg := db.Conn()
+
+	tx, err := pg.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("update profiles.users set totp_enabled=false,totp_secret=null,totp_last_step=0 where user_id=$1", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("delete from profiles.recovery_codes where user_id=$1", userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// verifySecondFactor проверка кода TOTP, либо одноразового кода восстановления
+func (srv *AuthService) verifySecondFactor(userID uint64, code string) error {
+	pg := db.Conn()
+
+	var secret sql.NullString
+	var totpEnabled bool
+	err := pg.QueryRow("select u.totp_secret,u.totp_enabled from profiles.users u where u.user_id=$1", userID).Scan(&secret, &totpEnabled)
+	if err != nil {
+		return err
+	}
+	if !totpEnabled || !secret.Valid {
+		return ErrTOTPNotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+
+	ok, step, err := utils.ValidateTOTP(secret.String, code, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		// один и тот же код нельзя использовать дважды
+		result, err := pg.Exec("update profiles.users set totp_last_step=$1 where user_id=$2 and totp_last_step<$1", step, userID)
+		if err != nil {
+			return err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	result, err := pg.Exec("update profiles.recovery_codes set used_at=now() where user_id=$1 and code_hash=$2 and used_at is null", userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrInvalidTOTPCode
+	}
+
+	log.Printf("user_id=%d used recovery code", userID)
+	return nil
+}
+
+func (srv *AuthService) replaceRecoveryCodes(tx *sql.Tx, userID uint64) ([]string, error) {
+	_, err := tx.Exec("delete from profiles.recovery_codes where user_id=$1", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec("insert into profiles.recovery_codes (user_id,code_hash) values ($1,$2)", userID, hashRecoveryCode(code))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// generateRecoveryCode код вида abcd-efgh
+func generateRecoveryCode() (string, error) {
+	raw := make([]byte, recoveryCodeSize)
+	_, err := rand.Read(raw)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+	return code[:recoveryCodeHalfLength] + "-" + code[recoveryCodeHalfLength:], nil
+}
+
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return sum[:]
+}
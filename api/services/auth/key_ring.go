@@ -0,0 +1,181 @@
+package auth_service
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"odo24_mobile_backend/api/services"
+	"odo24_mobile_backend/config"
+	"os"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKeyID    = errors.New("unknown key id")
+	ErrKeyExpired      = errors.New("key expired")
+	ErrNoSigningKey    = errors.New("active signing key not found")
+	ErrDuplicatedKeyID = errors.New("duplicated key id")
+)
+
+type ringKey struct {
+	kid       string
+	publicKey *rsa.PublicKey
+	notAfter  time.Time
+}
+
+/*
+KeyRing набор RSA ключей одного типа токенов.
+Подписывается только активный ключ, проверка - любым ключом из набора по заголовку kid,
+поэтому после ротации ранее выпущенные токены действуют до истечения срока.
+Ключи разбираются один раз при старте
+*/
+type KeyRing struct {
+	activeKid  string
+	signingKey *rsa.PrivateKey
+	keys       map[string]ringKey
+	order      []string
+}
+
+// NewKeyRing чтение ключей из файлов. Приватный ключ нужен только для активного ключа
+func NewKeyRing(cfg config.JwtKeyRing) (*KeyRing, error) {
+	ring := &KeyRing{
+		keys: make(map[string]ringKey),
+	}
+
+	for _, keyCfg := range cfg.Keys {
+		rawPublicKey, err := os.ReadFile(keyCfg.PublicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(rawPublicKey)
+		if err != nil {
+			log.Printf("error parsing RSA public key %s: %v\n", keyCfg.PublicKeyPath, err)
+			return nil, services.ErrorParsingRSAPublicKey
+		}
+
+		key := ringKey{
+			kid:       keyCfg.Kid,
+			publicKey: publicKey,
+		}
+		if key.kid == "" {
+			key.kid = thumbprint(publicKey)
+		}
+		if keyCfg.NotAfter != "" {
+			key.notAfter, err = time.Parse(time.RFC3339, keyCfg.NotAfter)
+			if err != nil {
+				return nil, fmt.Errorf("key %s not_after: %w", key.kid, err)
+			}
+		}
+		if _, ok := ring.keys[key.kid]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicatedKeyID, key.kid)
+		}
+
+		isActive := key.kid == cfg.ActiveKid || (cfg.ActiveKid == "" && len(ring.order) == 0)
+		if isActive {
+			rawPrivateKey, err := os.ReadFile(keyCfg.PrivateKeyPath)
+			if err != nil {
+				return nil, err
+			}
+			ring.signingKey, err = jwt.ParseRSAPrivateKeyFromPEM(rawPrivateKey)
+			if err != nil {
+				log.Printf("error parsing RSA private key %s: %v\n", keyCfg.PrivateKeyPath, err)
+				return nil, services.ErrorParsingRSAPrivateKey
+			}
+			ring.activeKid = key.kid
+		}
+
+		ring.keys[key.kid] = key
+		ring.order = append(ring.order, key.kid)
+	}
+
+	if ring.signingKey == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	return ring, nil
+}
+
+// Sign подпись токена активным ключом с заголовком kid
+func (ring *KeyRing) Sign(token *jwt.Token) (string, error) {
+	token.Header["kid"] = ring.activeKid
+	return token.SignedString(ring.signingKey)
+}
+
+// Keyfunc выбор ключа проверки по kid. Токены без kid (выпущенные до ротации) проверяются всеми ключами
+func (ring *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	now := time.Now()
+
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		keySet := jwt.VerificationKeySet{}
+		for _, id := range ring.order {
+			key := ring.keys[id]
+			if key.notAfter.IsZero() || now.Before(key.notAfter) {
+				keySet.Keys = append(keySet.Keys, key.publicKey)
+			}
+		}
+		return keySet, nil
+	}
+
+	key, ok := ring.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKeyID, kid)
+	}
+	if !key.notAfter.IsZero() && now.After(key.notAfter) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyExpired, kid)
+	}
+
+	return key.publicKey, nil
+}
+
+// JWKS публичные ключи в формате RFC 7517
+func (ring *KeyRing) JWKS() JWKSModel {
+	result := JWKSModel{
+		Keys: make([]JWKModel, 0, len(ring.order)),
+	}
+
+	now := time.Now()
+	for _, kid := range ring.order {
+		key := ring.keys[kid]
+		if !key.notAfter.IsZero() && now.After(key.notAfter) {
+			continue
+		}
+
+		result.Keys = append(result.Keys, JWKModel{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(key.publicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.publicKey.E)).Bytes()),
+		})
+	}
+
+	return result
+}
+
+// thumbprint kid по умолчанию - отпечаток ключа по RFC 7638
+func thumbprint(publicKey *rsa.PublicKey) string {
+	body, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+	})
+	sum := sha256.Sum256(body)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,14 @@
+package auth_service
+
+type JWKSModel struct {
+	Keys []JWKModel `json:"keys"`
+}
+
+type JWKModel struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
@@ -4,6 +4,7 @@ type CarServiceModel struct {
 	ServiceID    uint64  `json:"service_id"`
 	Odo          *uint32 `json:"odo"`
 	NextDistance *uint32 `json:"next_distance"`
+	NextMonths   *uint32 `json:"next_months"`
 	Dt           string  `json:"dt"`
 	Description  *string `json:"description"`
 	Price        *uint32 `json:"price"`
@@ -14,6 +15,7 @@ type CarServiceCreateModel struct {
 	GroupID      uint64
 	Odo          *uint32
 	NextDistance *uint32
+	NextMonths   *uint32
 	Dt           string
 	Description  *string
 	Price        *uint32
@@ -22,7 +24,66 @@ type CarServiceUpdateModel struct {
 	ServiceID    uint64
 	Odo          *uint32
 	NextDistance *uint32
+	NextMonths   *uint32
 	Dt           string
 	Description  *string
 	Price        *uint32
 }
+
+// ServicesQueryModel параметры выборки записей, nil и пустые значения не фильтруют
+type ServicesQueryModel struct {
+	Limit       int
+	Cursor      string
+	Sort        string
+	Order       string
+	DateFrom    *string
+	DateTo      *string
+	OdoFrom     *uint32
+	OdoTo       *uint32
+	PriceFrom   *uint32
+	PriceTo     *uint32
+	Description string
+}
+
+type ServicesPageModel struct {
+	Items      []CarServiceModel `json:"items"`
+	NextCursor *string           `json:"next_cursor"`
+	HasMore    bool              `json:"has_more"`
+}
+
+// TimelineServiceModel запись с группой для общей ленты обслуживания авто
+type TimelineServiceModel struct {
+	CarServiceModel
+	GroupID   uint64 `json:"group_id"`
+	GroupName string `json:"group_name"`
+}
+
+type TimelinePageModel struct {
+	Items      []TimelineServiceModel `json:"items"`
+	NextCursor *string                `json:"next_cursor"`
+	HasMore    bool                   `json:"has_more"`
+}
+
+type SearchQueryModel struct {
+	Query  string
+	CarID  *uint64
+	Limit  int
+	Cursor string
+}
+
+// SearchResultModel найденная запись, Snippet - HTML-экранированный фрагмент описания с совпадениями в <b></b>
+type SearchResultModel struct {
+	CarServiceModel
+	CarID     uint64  `json:"car_id"`
+	CarName   string  `json:"car_name"`
+	GroupID   uint64  `json:"group_id"`
+	GroupName string  `json:"group_name"`
+	Rank      float64 `json:"rank"`
+	Snippet   string  `json:"snippet"`
+}
+
+type SearchPageModel struct {
+	Items      []SearchResultModel `json:"items"`
+	NextCursor *string             `json:"next_cursor"`
+	HasMore    bool                `json:"has_more"`
+}
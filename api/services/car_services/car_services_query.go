@@ -0,0 +1,252 @@
+package car_services_service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"odo24_mobile_backend/db"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+
+	SortDt    = "dt"
+	SortOdo   = "odo"
+	SortPrice = "price"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort")
+)
+
+// sortColumn выражение сортировки и тип значения в курсоре. Пустые odo и price идут как -1
+type sortColumn struct {
+	expr     string
+	castType string
+}
+
+var sortColumns = map[string]sortColumn{
+	SortDt:    {expr: "s.dt", castType: "date"},
+	SortOdo:   {expr: "coalesce(s.odo,-1)", castType: "bigint"},
+	SortPrice: {expr: "coalesce(s.price,-1)", castType: "bigint"},
+}
+
+// servicesCursor позиция последней записи страницы, привязана к сортировке
+type servicesCursor struct {
+	Sort      string `json:"s"`
+	Order     string `json:"o"`
+	Value     string `json:"v"`
+	ServiceID uint64 `json:"id"`
+}
+
+func (cursor servicesCursor) encode() string {
+	body, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(body)
+}
+
+func decodeCursor(value string) (*servicesCursor, error) {
+	body, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor servicesCursor
+	err = json.Unmarshal(body, &cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// значение подставляется в запрос с приведением типа, подделанный курсор не должен давать ошибку Postgres
+	column, ok := sortColumns[cursor.Sort]
+	if !ok || !column.valid(cursor.Value) || cursor.ServiceID > math.MaxInt64 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// valid значение курсора приводится к типу колонки сортировки
+func (column sortColumn) valid(value string) bool {
+	switch column.castType {
+	case "date":
+		_, err := time.Parse("2006-01-02", value)
+		return err == nil
+	case "bigint":
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	}
+	return false
+}
+
+// GetServicesPage страница записей авто в одной группе
+func (srv *CarServicesService) GetServicesPage(carID, groupID uint64, query ServicesQueryModel) (*ServicesPageModel, error) {
+	rows, hasMore, nextCursor, err := srv.queryPage([]string{"s.car_id=$1", "s.group_id=$2"}, []interface{}{carID, groupID}, query)
+	if err != nil {
+		return nil, err
+	}
+
+	page := ServicesPageModel{
+		Items:      make([]CarServiceModel, 0, len(rows)),
+		NextCursor: nextCursor,
+		HasMore:    hasMore,
+	}
+	for _, row := range rows {
+		page.Items = append(page.Items, row.CarServiceModel)
+	}
+	return &page, nil
+}
+
+// GetCarTimeline страница записей авто по всем группам с названием группы, groupIDs - необязательный фильтр групп
+func (srv *CarServicesService) GetCarTimeline(carID uint64, groupIDs []uint64, query ServicesQueryModel) (*TimelinePageModel, error) {
+	conditions := []string{"s.car_id=$1"}
+	args := []interface{}{carID}
+	if len(groupIDs) > 0 {
+		conditions = append(conditions, "s.group_id=ANY($2)")
+		args = append(args, pq.Array(groupIDs))
+	}
+
+	rows, hasMore, nextCursor, err := srv.queryPage(conditions, args, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TimelinePageModel{
+		Items:      rows,
+		NextCursor: nextCursor,
+		HasMore:    hasMore,
+	}, nil
+}
+
+/*
+queryPage выборка страницы записей с фильтрами и сортировкой, conditions и args - обязательные условия.
+Пагинация по курсору: следующая страница начинается строго после последней записи предыдущей,
+поэтому добавление и удаление записей между запросами не приводит к пропускам и повторам
+*/
+func (srv *CarServicesService) queryPage(conditions []string, args []interface{}, query ServicesQueryModel) ([]TimelineServiceModel, bool, *string, error) {
+	if query.Sort == "" {
+		query.Sort = SortDt
+	}
+	if query.Order == "" {
+		query.Order = OrderDesc
+	}
+	column, ok := sortColumns[query.Sort]
+	if !ok || (query.Order != OrderAsc && query.Order != OrderDesc) {
+		return nil, false, nil, ErrInvalidSort
+	}
+	if query.Limit <= 0 {
+		query.Limit = DefaultPageLimit
+	}
+	if query.Limit > MaxPageLimit {
+		query.Limit = MaxPageLimit
+	}
+
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if query.DateFrom != nil {
+		conditions = append(conditions, "s.dt>="+arg(*query.DateFrom)+"::date")
+	}
+	if query.DateTo != nil {
+		conditions = append(conditions, "s.dt<="+arg(*query.DateTo)+"::date")
+	}
+	if query.OdoFrom != nil {
+		conditions = append(conditions, "s.odo>="+arg(*query.OdoFrom))
+	}
+	if query.OdoTo != nil {
+		conditions = append(conditions, "s.odo<="+arg(*query.OdoTo))
+	}
+	if query.PriceFrom != nil {
+		conditions = append(conditions, "s.price>="+arg(*query.PriceFrom))
+	}
+	if query.PriceTo != nil {
+		conditions = append(conditions, "s.price<="+arg(*query.PriceTo))
+	}
+	if query.Description != "" {
+		conditions = append(conditions, "s.description ILIKE "+arg("%"+escapeLike(query.Description)+"%"))
+	}
+
+	comparison := ">"
+	if query.Order == OrderDesc {
+		comparison = "<"
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, false, nil, err
+		}
+		if cursor.Sort != query.Sort || cursor.Order != query.Order {
+			return nil, false, nil, ErrInvalidCursor
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s,s.service_id)%s(%s::%s,%s)",
+			column.expr, comparison, arg(cursor.Value), column.castType, arg(cursor.ServiceID)))
+	}
+
+	// лишняя запись показывает, есть ли следующая страница
+	sqlQuery := fmt.Sprintf(`SELECT s.service_id,s.odo,s.next_distance,s.next_months,s.dt,s.description,s.price,s.group_id,g."name",(%s)::text
+		FROM service_book.services s
+		INNER JOIN service_book.service_groups g ON g.group_id=s.group_id
+		WHERE %s
+		ORDER BY %s %s,s.service_id %s
+		LIMIT %s`, column.expr, strings.Join(conditions, " AND "), column.expr, query.Order, query.Order, arg(query.Limit+1))
+
+	pg := db.Conn()
+	rows, err := pg.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, false, nil, err
+	}
+
+	defer rows.Close()
+
+	items := []TimelineServiceModel{}
+	hasMore := false
+
+	var lastSortValue string
+	for rows.Next() {
+		var model TimelineServiceModel
+		var sortValue string
+		err := rows.Scan(&model.ServiceID, &model.Odo, &model.NextDistance, &model.NextMonths, &model.Dt, &model.Description, &model.Price, &model.GroupID, &model.GroupName, &sortValue)
+		if err != nil {
+			return nil, false, nil, err
+		}
+
+		if len(items) == query.Limit {
+			hasMore = true
+			break
+		}
+		items = append(items, model)
+		lastSortValue = sortValue
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, nil, err
+	}
+
+	if !hasMore {
+		return items, false, nil, nil
+	}
+
+	nextCursor := servicesCursor{
+		Sort:      query.Sort,
+		Order:     query.Order,
+		Value:     lastSortValue,
+		ServiceID: items[len(items)-1].ServiceID,
+	}.encode()
+	return items, true, &nextCursor, nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
@@ -0,0 +1,102 @@
+package car_services_service
+
+import (
+	"odo24_mobile_backend/db"
+	"strconv"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+/*
+Search полнотекстовый поиск по записям пользователя с русской морфологией.
+Совпадения в описании весят больше, чем в названии группы и авто.
+Поиск по описанию и по названиям - отдельные ветки UNION, чтобы описание искалось по services_description_fts_idx.
+Курсор - смещение следующей страницы, результаты упорядочены по релевантности
+*/
+func (srv *CarServicesService) Search(userID uint64, query SearchQueryModel) (*SearchPageModel, error) {
+	if query.Limit <= 0 {
+		query.Limit = DefaultSearchLimit
+	}
+	if query.Limit > MaxSearchLimit {
+		query.Limit = MaxSearchLimit
+	}
+
+	offset := 0
+	if query.Cursor != "" {
+		var err error
+		offset, err = strconv.Atoi(query.Cursor)
+		if err != nil || offset < 0 {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	pg := db.Conn()
+	rows, err := pg.Query(`WITH q AS (SELECT websearch_to_tsquery('russian', $2) AS query),
+		matched AS (
+			SELECT s.service_id
+			FROM service_book.services s
+			INNER JOIN service_book.car c ON c.car_id=s.car_id
+			CROSS JOIN q
+			WHERE c.user_id=$1 AND ($3::bigint IS NULL OR s.car_id=$3)
+			AND to_tsvector('russian', coalesce(s.description, '')) @@ q.query
+			UNION
+			SELECT s.service_id
+			FROM service_book.services s
+			INNER JOIN service_book.car c ON c.car_id=s.car_id
+			INNER JOIN service_book.service_groups g ON g.group_id=s.group_id
+			CROSS JOIN q
+			WHERE c.user_id=$1 AND ($3::bigint IS NULL OR s.car_id=$3)
+			AND (to_tsvector('russian', g."name") @@ q.query OR to_tsvector('russian', c."name") @@ q.query)
+		)
+		SELECT s.service_id,s.car_id,c."name",s.group_id,g."name",s.dt,s.odo,s.next_distance,s.next_months,s.price,s.description,
+			ts_rank(
+				setweight(to_tsvector('russian', coalesce(s.description, '')), 'A') ||
+				setweight(to_tsvector('russian', g."name"), 'B') ||
+				setweight(to_tsvector('russian', c."name"), 'C'),
+				q.query) AS rank,
+			ts_headline('russian',
+				replace(replace(replace(replace(replace(coalesce(s.description, ''), '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;'),
+				q.query, 'StartSel=<b>, StopSel=</b>, MaxFragments=2, MaxWords=20, MinWords=5')
+		FROM service_book.services s
+		INNER JOIN service_book.car c ON c.car_id=s.car_id
+		INNER JOIN service_book.service_groups g ON g.group_id=s.group_id
+		INNER JOIN matched m ON m.service_id=s.service_id
+		CROSS JOIN q
+		ORDER BY rank DESC,s.dt DESC,s.service_id DESC
+		LIMIT $4 OFFSET $5`, userID, query.Query, query.CarID, query.Limit+1, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	page := SearchPageModel{
+		Items: []SearchResultModel{},
+	}
+	for rows.Next() {
+		var model SearchResultModel
+		err := rows.Scan(&model.ServiceID, &model.CarID, &model.CarName, &model.GroupID, &model.GroupName, &model.Dt, &model.Odo, &model.NextDistance, &model.NextMonths, &model.Price, &model.Description, &model.Rank, &model.Snippet)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(page.Items) == query.Limit {
+			page.HasMore = true
+			break
+		}
+		page.Items = append(page.Items, model)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if page.HasMore {
+		nextCursor := strconv.Itoa(offset + query.Limit)
+		page.NextCursor = &nextCursor
+	}
+
+	return &page, nil
+}
@@ -1,7 +1,9 @@
 package car_services_service
 
 import (
+	"database/sql"
 	"odo24_mobile_backend/api/services"
+	cars_service "odo24_mobile_backend/api/services/cars"
 	"odo24_mobile_backend/db"
 )
 
@@ -14,7 +16,7 @@ func NewCarServicesService() *CarServicesService {
 func (srv *CarServicesService) GetServices(carID, groupID uint64) ([]CarServiceModel, error) {
 	pg := db.Conn()
 
-	rows, err := pg.Query(`SELECT s.service_id,s.odo,s.next_distance,s.dt,s.description,s.price FROM service_book.services s WHERE s.car_id=$1 AND s.group_id=$2`, carID, groupID)
+	rows, err := pg.Query(`SELECT s.service_id,s.odo,s.next_distance,s.next_months,s.dt,s.description,s.price FROM service_book.services s WHERE s.car_id=$1 AND s.group_id=$2 ORDER BY s.dt,s.service_id`, carID, groupID)
 	if err != nil {
 		return nil, err
 	}
@@ -25,7 +27,7 @@ func (srv *CarServicesService) GetServices(carID, groupID uint64) ([]CarServiceM
 
 	for rows.Next() {
 		var model CarServiceModel
-		err := rows.Scan(&model.ServiceID, &model.Odo, &model.NextDistance, &model.Dt, &model.Description, &model.Price)
+		err := rows.Scan(&model.ServiceID, &model.Odo, &model.NextDistance, &model.NextMonths, &model.Dt, &model.Description, &model.Price)
 		if err != nil {
 			return nil, err
 		}
@@ -36,19 +38,46 @@ func (srv *CarServicesService) GetServices(carID, groupID uint64) ([]CarServiceM
 	return result, nil
 }
 
+// Create запись и показание одометра в одной транзакции, иначе повтор после ошибки создал бы дубль записи
 func (srv *CarServicesService) Create(body CarServiceCreateModel) (*CarServiceModel, error) {
-	pg := db.Conn()
+	tx, err := db.Conn().Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
+	model, err := srv.create(tx, body)
+	if err != nil {
+		return nil, err
+	}
+
+	return model, tx.Commit()
+}
+
+// CreateTx создание записи в транзакции вызывающего
+func (srv *CarServicesService) CreateTx(tx *sql.Tx, body CarServiceCreateModel) (*CarServiceModel, error) {
+	return srv.create(tx, body)
+}
+
+func (srv *CarServicesService) create(pg services.Querier, body CarServiceCreateModel) (*CarServiceModel, error) {
 	var carServiceID uint64
-	err := pg.QueryRow(`INSERT INTO service_book.services (car_id,group_id,odo,next_distance,dt,description,price) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING service_id`, body.CarID, body.GroupID, body.Odo, body.NextDistance, body.Dt, body.Description, body.Price).Scan(&carServiceID)
+	err := pg.QueryRow(`INSERT INTO service_book.services (car_id,group_id,odo,next_distance,next_months,dt,description,price) VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING service_id`, body.CarID, body.GroupID, body.Odo, body.NextDistance, body.NextMonths, body.Dt, body.Description, body.Price).Scan(&carServiceID)
 	if err != nil {
 		return nil, err
 	}
 
+	if body.Odo != nil {
+		err = cars_service.RecordOdo(pg, body.CarID, *body.Odo, cars_service.OdoSourceService, &body.Dt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &CarServiceModel{
 		ServiceID:    carServiceID,
 		Odo:          body.Odo,
 		NextDistance: body.NextDistance,
+		NextMonths:   body.NextMonths,
 		Dt:           body.Dt,
 		Description:  body.Description,
 		Price:        body.Price,
@@ -58,7 +87,7 @@ func (srv *CarServicesService) Create(body CarServiceCreateModel) (*CarServiceMo
 func (srv *CarServicesService) Update(body CarServiceUpdateModel) error {
 	pg := db.Conn()
 
-	_, err := pg.Exec(`UPDATE service_book.services SET odo=$1,next_distance=$2,dt=$3,description=$4,price=$5 WHERE service_id=$6`, body.Odo, body.NextDistance, body.Dt, body.Description, body.Price, body.ServiceID)
+	_, err := pg.Exec(`UPDATE service_book.services SET odo=$1,next_distance=$2,next_months=$3,dt=$4,description=$5,price=$6 WHERE service_id=$7`, body.Odo, body.NextDistance, body.NextMonths, body.Dt, body.Description, body.Price, body.ServiceID)
 	if err != nil {
 		return err
 	}
@@ -1,5 +1,7 @@
 package cars_service
 
+import "time"
+
 type CarModel struct {
 	CarID         uint64       `json:"car_id"`
 	Name          string       `json:"name"`
@@ -19,9 +21,50 @@ type CarExtData struct {
 	Odo       uint32 `json:"odo"`
 	NextOdo   uint32 `json:"next_odo"`
 	GroupName string `json:"group_name"`
+	// NextDistance интервал по пробегу, nil если правила по пробегу нет (тогда Odo и NextOdo нулевые)
+	NextDistance *uint32 `json:"next_distance"`
+	NextMonths   *uint32 `json:"next_months"`
+	// Dt дата последнего обслуживания группы
+	Dt string `json:"dt"`
+	// NextDate срок обслуживания по времени, nil если интервал в месяцах не задан
+	NextDate *string `json:"next_date"`
+	// DueDate срок обслуживания по пробегу (прогноз) или по времени - что раньше, nil если срока нет
+	DueDate *string `json:"due_date"`
+	// DaysLeft дней до обслуживания, отрицательное значение - срок прошел
+	DaysLeft *int `json:"days_left"`
+	// DueBy что наступит раньше: DueByDistance или DueByTime
+	DueBy *string `json:"due_by"`
 }
 
+const (
+	DueByDistance = "distance"
+	DueByTime     = "time"
+)
+
 type rowGroup struct {
-	Odo     uint32
-	NextOdo uint32
+	ServiceID  uint64
+	Odo        *uint32
+	NextOdo    *uint32
+	NextMonths *uint32
+	Dt         string
+	NextDate   *string
+}
+
+type OdoReadingModel struct {
+	Odo        uint32    `json:"odo"`
+	Source     string    `json:"source"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+type OdoHistoryModel struct {
+	Readings []OdoReadingModel `json:"readings"`
+	// DailyDistance средний суточный пробег, nil если показаний недостаточно
+	DailyDistance *float64 `json:"daily_distance"`
+}
+
+// MileageForecast средний суточный пробег и последнее показание, от которого строится прогноз
+type MileageForecast struct {
+	DailyDistance float64
+	LastOdo       uint32
+	LastAt        time.Time
 }
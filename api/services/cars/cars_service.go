@@ -1,6 +1,7 @@
 package cars_service
 
 import (
+	"database/sql"
 	"odo24_mobile_backend/api/services"
 	"odo24_mobile_backend/db"
 
@@ -40,19 +41,35 @@ func (srv *CarsService) GetCarsByUser(userID uint64) ([]CarModel, error) {
 	return cars, nil
 }
 
+func (srv *CarsService) GetCar(carID uint64) (*CarModel, error) {
+	pg := db.Conn()
+
+	var car CarModel
+	err := pg.QueryRow(`SELECT c.car_id, c."name", c.odo, c.avatar, (SELECT count(*) FROM service_book.services s WHERE s.car_id = c.car_id) services_total
+		FROM service_book.car c
+		WHERE c.car_id=$1`, carID).Scan(&car.CarID, &car.Name, &car.Odo, &car.Avatar, &car.ServicesTotal)
+	if err != nil {
+		return nil, err
+	}
+
+	return &car, nil
+}
+
+// GetCarNextServiceInformation интервалы последнего по дате обслуживания каждой группы
 func (srv *CarsService) GetCarNextServiceInformation(carIDs []uint64) (map[uint64]map[uint64]rowGroup, error) {
 	if len(carIDs) == 0 {
 		return nil, nil
 	}
 	pg := db.Conn()
 
-	rows, err := pg.Query(`SELECT g.car_id, g.group_id, g.odo, g.next_distance FROM (
-    SELECT s.car_id, s.group_id, s.odo, s.next_distance, row_number()
-    OVER (PARTITION BY s.car_id, s.group_id ORDER BY s.odo DESC) AS rownum
+	rows, err := pg.Query(`SELECT g.car_id, g.group_id, g.service_id, g.odo, g.next_distance, g.next_months,
+		to_char(g.dt, 'YYYY-MM-DD'), to_char(g.dt + make_interval(months => g.next_months), 'YYYY-MM-DD') FROM (
+    SELECT s.car_id, s.group_id, s.service_id, s.odo, s.next_distance, s.next_months, s.dt, row_number()
+    OVER (PARTITION BY s.car_id, s.group_id ORDER BY s.dt DESC, s.service_id DESC) AS rownum
 		FROM service_book.services s
 		WHERE s.car_id = ANY($1)
 	) g
-	WHERE rownum=1 and g.next_distance is not null;`, pq.Array(carIDs))
+	WHERE rownum=1 and (g.next_distance is not null or g.next_months is not null);`, pq.Array(carIDs))
 	if err != nil {
 		return nil, err
 	}
@@ -64,10 +81,9 @@ func (srv *CarsService) GetCarNextServiceInformation(carIDs []uint64) (map[uint6
 		var row struct {
 			CarID   uint64
 			GroupID uint64
-			Odo     uint32
-			NextOdo uint32
+			rowGroup
 		}
-		err := rows.Scan(&row.CarID, &row.GroupID, &row.Odo, &row.NextOdo)
+		err := rows.Scan(&row.CarID, &row.GroupID, &row.ServiceID, &row.Odo, &row.NextOdo, &row.NextMonths, &row.Dt, &row.NextDate)
 		if err != nil {
 			return nil, err
 		}
@@ -75,10 +91,7 @@ func (srv *CarsService) GetCarNextServiceInformation(carIDs []uint64) (map[uint6
 			rowsMap[row.CarID] = make(map[uint64]rowGroup)
 		}
 
-		rowsMap[row.CarID][row.GroupID] = rowGroup{
-			Odo:     row.Odo,
-			NextOdo: row.NextOdo,
-		}
+		rowsMap[row.CarID][row.GroupID] = row.rowGroup
 		mapGroupIDs[row.GroupID] = struct{}{}
 	}
 
@@ -86,14 +99,26 @@ func (srv *CarsService) GetCarNextServiceInformation(carIDs []uint64) (map[uint6
 }
 
 func (srv *CarsService) Create(userID uint64, carBody CarCreateModel) (*CarModel, error) {
-	pg := db.Conn()
+	return srv.create(db.Conn(), userID, carBody)
+}
+
+// CreateTx создание авто в транзакции вызывающего
+func (srv *CarsService) CreateTx(tx *sql.Tx, userID uint64, carBody CarCreateModel) (*CarModel, error) {
+	return srv.create(tx, userID, carBody)
+}
 
+func (srv *CarsService) create(pg services.Querier, userID uint64, carBody CarCreateModel) (*CarModel, error) {
 	var carID uint64
 	err := pg.QueryRow(`INSERT INTO service_book.car (user_id,"name",odo,avatar) VALUES ($1,$2,$3,$4) RETURNING car_id`, userID, carBody.Name, carBody.Odo, carBody.Avatar).Scan(&carID)
 	if err != nil {
 		return nil, err
 	}
 
+	err = RecordOdo(pg, carID, carBody.Odo, OdoSourceCar, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	return &CarModel{
 		CarID:  carID,
 		Name:   carBody.Name,
@@ -105,22 +130,44 @@ func (srv *CarsService) Create(userID uint64, carBody CarCreateModel) (*CarModel
 func (srv *CarsService) Update(carBody CarModel) error {
 	pg := db.Conn()
 
-	_, err := pg.Exec(`UPDATE service_book.car SET "name"=$1,odo=$2,avatar=$3 WHERE car_id=$4`, carBody.Name, carBody.Odo, carBody.Avatar, carBody.CarID)
+	tx, err := pg.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	err = recordOdoChange(tx, carBody.CarID, carBody.Odo)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE service_book.car SET "name"=$1,odo=$2,avatar=$3 WHERE car_id=$4`, carBody.Name, carBody.Odo, carBody.Avatar, carBody.CarID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 func (srv *CarsService) UpdateODO(carID uint64, odo uint32) error {
 	pg := db.Conn()
 
-	_, err := pg.Exec(`UPDATE service_book.car SET odo=$1 WHERE car_id=$2`, odo, carID)
+	tx, err := pg.Begin()
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	err = recordOdoChange(tx, carID, odo)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE service_book.car SET odo=$1 WHERE car_id=$2`, odo, carID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (srv *CarsService) Delete(carID uint64) error {
@@ -0,0 +1,39 @@
+package cars_service
+
+import "time"
+
+/*
+SetDue срок следующего обслуживания группы по правилу "что наступит раньше":
+по пробегу Odo+NextDistance (дата по прогнозу среднего суточного пробега) или по времени NextDate.
+forecast nil - прогноза пробега нет, учитывается только срок по времени
+*/
+func (d *CarExtData) SetDue(forecast *MileageForecast, now time.Time) {
+	var due time.Time
+	var dueBy string
+
+	if forecast != nil && d.NextDistance != nil {
+		due = forecast.DueDate(d.Odo + *d.NextDistance)
+		dueBy = DueByDistance
+	}
+
+	if d.NextDate != nil {
+		nextDate, err := time.Parse(time.DateOnly, *d.NextDate)
+		if err == nil && (dueBy == "" || nextDate.Before(due)) {
+			due = nextDate
+			dueBy = DueByTime
+		}
+	}
+
+	if dueBy == "" {
+		return
+	}
+
+	dueDate := due.Format(time.DateOnly)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	dueDay := time.Date(due.Year(), due.Month(), due.Day(), 0, 0, 0, 0, time.UTC)
+	daysLeft := int(dueDay.Sub(today).Hours() / 24)
+
+	d.DueDate = &dueDate
+	d.DaysLeft = &daysLeft
+	d.DueBy = &dueBy
+}
@@ -0,0 +1,137 @@
+package cars_service
+
+import (
+	"database/sql"
+	"math"
+	"odo24_mobile_backend/api/services"
+	"odo24_mobile_backend/db"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// источники показаний одометра
+const (
+	OdoSourceCar     = "car"
+	OdoSourceService = "service"
+)
+
+const (
+	// forecastWindow показания старше не учитываются, средний пробег меняется со временем
+	forecastWindow = time.Hour * 24 * 365
+	// forecastMinDays меньший интервал между показаниями дает слишком грубый прогноз
+	forecastMinDays = 14
+	odoHistoryLimit = 500
+)
+
+// RecordOdo запись показания одометра, повтор последнего показания авто не сохраняется.
+// recordedAt nil - текущее время
+func RecordOdo(pg services.Querier, carID uint64, odo uint32, source string, recordedAt *string) error {
+	_, err := pg.Exec(`INSERT INTO service_book.odo_history (car_id,odo,source,recorded_at)
+		SELECT $1,$2,$3,coalesce($4::timestamp,now())
+		WHERE coalesce((SELECT h.odo FROM service_book.odo_history h
+			WHERE h.car_id=$1
+			ORDER BY h.recorded_at DESC,h.odo_history_id DESC
+			LIMIT 1), -1)<>$2`, carID, odo, source, recordedAt)
+	return err
+}
+
+// GetOdoHistory показания одометра авто, новые первыми
+func (srv *CarsService) GetOdoHistory(carID uint64) ([]OdoReadingModel, error) {
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT h.odo,h.source,h.recorded_at
+		FROM service_book.odo_history h
+		WHERE h.car_id=$1
+		ORDER BY h.recorded_at DESC,h.odo_history_id DESC
+		LIMIT $2`, carID, odoHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := []OdoReadingModel{}
+	for rows.Next() {
+		var model OdoReadingModel
+		err := rows.Scan(&model.Odo, &model.Source, &model.RecordedAt)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, model)
+	}
+
+	return result, rows.Err()
+}
+
+/*
+GetMileageForecast средний суточный пробег авто по показаниям одометра за последний год.
+Пробег считается наклоном прямой по всем показаниям (МНК), а не по крайним значениям:
+одно ошибочное показание не сдвигает прогноз целиком. Авто без достаточной истории в результат не попадают
+*/
+func (srv *CarsService) GetMileageForecast(carIDs []uint64) (map[uint64]*MileageForecast, error) {
+	if len(carIDs) == 0 {
+		return nil, nil
+	}
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT h.car_id,
+			regr_slope(h.odo,extract(epoch FROM h.recorded_at)/86400),
+			(array_agg(h.odo ORDER BY h.recorded_at DESC,h.odo_history_id DESC))[1],
+			min(h.recorded_at),max(h.recorded_at)
+		FROM service_book.odo_history h
+		WHERE h.car_id = ANY($1) AND h.recorded_at>=$2
+		GROUP BY h.car_id`, pq.Array(carIDs), time.Now().Add(-forecastWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := make(map[uint64]*MileageForecast)
+	for rows.Next() {
+		var carID uint64
+		var dailyDistance sql.NullFloat64
+		var lastOdo uint32
+		var firstAt, lastAt time.Time
+		err := rows.Scan(&carID, &dailyDistance, &lastOdo, &firstAt, &lastAt)
+		if err != nil {
+			return nil, err
+		}
+
+		days := lastAt.Sub(firstAt).Hours() / 24
+		if days < forecastMinDays || !dailyDistance.Valid || dailyDistance.Float64 <= 0 {
+			continue
+		}
+
+		result[carID] = &MileageForecast{
+			DailyDistance: dailyDistance.Float64,
+			LastOdo:       lastOdo,
+			LastAt:        lastAt,
+		}
+	}
+
+	return result, rows.Err()
+}
+
+// DueDate дата, когда пробег достигнет targetOdo при сохранении среднего суточного пробега
+func (f MileageForecast) DueDate(targetOdo uint32) time.Time {
+	if targetOdo <= f.LastOdo {
+		return f.LastAt
+	}
+	days := math.Ceil(float64(targetOdo-f.LastOdo) / f.DailyDistance)
+	return f.LastAt.AddDate(0, 0, int(days))
+}
+
+// recordOdoChange запись показания, если пробег авто изменился
+func recordOdoChange(tx *sql.Tx, carID uint64, odo uint32) error {
+	var prevOdo uint32
+	err := tx.QueryRow(`SELECT c.odo FROM service_book.car c WHERE c.car_id=$1 FOR UPDATE`, carID).Scan(&prevOdo)
+	if err != nil {
+		return err
+	}
+	if prevOdo == odo {
+		return nil
+	}
+	return RecordOdo(tx, carID, odo, OdoSourceCar, nil)
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"odo24_mobile_backend/db"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	CodeStoreMemcache = "memcache"
+	CodeStoreMemory   = "memory"
+	CodeStorePostgres = "postgres"
+)
+
+/*
+CodeStore хранилище кодов подтверждения с ограниченным временем жизни, отсутствие ключа - nil без ошибки.
+Add сохраняет значение, только если ключа нет, false - ключ уже есть.
+CompareAndSwap заменяет значение, только если оно все еще равно old (new nil - удаление), false - значение уже изменилось
+*/
+type CodeStore interface {
+	Get(key string) ([]byte, error)
+	Add(key string, value []byte, ttl time.Duration) (bool, error)
+	CompareAndSwap(key string, old, new []byte, ttl time.Duration) (bool, error)
+	Delete(key string) error
+}
+
+// NewCodeStore хранилище по имени из настроек, по умолчанию memcache
+func NewCodeStore(store string, memc *memcache.Client) CodeStore {
+	switch store {
+	case CodeStoreMemory:
+		return NewMemoryCodeStore()
+	case CodeStorePostgres:
+		return NewPostgresCodeStore()
+	default:
+		return NewMemcacheCodeStore(memc)
+	}
+}
+
+type MemcacheCodeStore struct {
+	memc *memcache.Client
+}
+
+func NewMemcacheCodeStore(memc *memcache.Client) *MemcacheCodeStore {
+	return &MemcacheCodeStore{
+		memc: memc,
+	}
+}
+
+func (s *MemcacheCodeStore) Get(key string) ([]byte, error) {
+	item, err := s.memc.Get(key)
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// пустое значение - удаленный через CompareAndSwap код
+	if len(item.Value) == 0 {
+		return nil, nil
+	}
+	return item.Value, nil
+}
+
+// Add через Add memcache, удаленный код (пустое значение) заменяется через CAS
+func (s *MemcacheCodeStore) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	err := s.memc.Add(&memcache.Item{
+		Key:        key,
+		Value:      value,
+		Expiration: int32(ttl.Seconds()),
+	})
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, memcache.ErrNotStored) {
+		return false, err
+	}
+
+	return s.CompareAndSwap(key, []byte{}, value, ttl)
+}
+
+// CompareAndSwap через CAS memcache, удаление - замена на пустое значение с коротким сроком жизни
+func (s *MemcacheCodeStore) CompareAndSwap(key string, old, new []byte, ttl time.Duration) (bool, error) {
+	item, err := s.memc.Get(key)
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !bytes.Equal(item.Value, old) {
+		return false, nil
+	}
+
+	item.Value = new
+	item.Expiration = int32(ttl.Seconds())
+	if new == nil {
+		item.Value = []byte{}
+		item.Expiration = 1
+	}
+
+	err = s.memc.CompareAndSwap(item)
+	if err != nil {
+		if errors.Is(err, memcache.ErrCASConflict) || errors.Is(err, memcache.ErrNotStored) || errors.Is(err, memcache.ErrCacheMiss) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *MemcacheCodeStore) Delete(key string) error {
+	err := s.memc.Delete(key)
+	if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return err
+	}
+	return nil
+}
+
+// MemoryCodeStore хранилище в памяти процесса, для тестов и небольших установок без memcached
+type MemoryCodeStore struct {
+	mx    sync.Mutex
+	items map[string]memoryCodeItem
+}
+
+type memoryCodeItem struct {
+	value      []byte
+	expiration time.Time
+}
+
+func NewMemoryCodeStore() *MemoryCodeStore {
+	return &MemoryCodeStore{
+		items: make(map[string]memoryCodeItem),
+	}
+}
+
+func (s *MemoryCodeStore) Get(key string) ([]byte, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	item, ok := s.items[key]
+	if !ok || time.Now().After(item.expiration) {
+		return nil, nil
+	}
+	return append([]byte(nil), item.value...), nil
+}
+
+func (s *MemoryCodeStore) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	now := time.Now()
+	for k, item := range s.items {
+		if now.After(item.expiration) {
+			delete(s.items, k)
+		}
+	}
+
+	if _, ok := s.items[key]; ok {
+		return false, nil
+	}
+
+	s.items[key] = memoryCodeItem{
+		value:      append([]byte(nil), value...),
+		expiration: now.Add(ttl),
+	}
+	return true, nil
+}
+
+func (s *MemoryCodeStore) CompareAndSwap(key string, old, new []byte, ttl time.Duration) (bool, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	item, ok := s.items[key]
+	if !ok || time.Now().After(item.expiration) || !bytes.Equal(item.value, old) {
+		return false, nil
+	}
+
+	if new == nil {
+		delete(s.items, key)
+		return true, nil
+	}
+
+	s.items[key] = memoryCodeItem{
+		value:      append([]byte(nil), new...),
+		expiration: time.Now().Add(ttl),
+	}
+	return true, nil
+}
+
+func (s *MemoryCodeStore) Delete(key string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	delete(s.items, key)
+	return nil
+}
+
+// PostgresCodeStore хранилище в таблице profiles.verification_codes, коды переживают перезапуск и общие для всех экземпляров
+type PostgresCodeStore struct{}
+
+func NewPostgresCodeStore() *PostgresCodeStore {
+	return &PostgresCodeStore{}
+}
+
+func (s *PostgresCodeStore) Get(key string) ([]byte, error) {
+	pg := db.Conn()
+
+	var value []byte
+	err := pg.QueryRow("select c.value from profiles.verification_codes c where c.key=$1 and c.expires_at>now()", key).Scan(&value)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// Add истекшая строка с тем же ключом считается отсутствующей и заменяется
+func (s *PostgresCodeStore) Add(key string, value []byte, ttl time.Duration) (bool, error) {
+	pg := db.Conn()
+
+	_, err := pg.Exec("delete from profiles.verification_codes where expires_at<=now()")
+	if err != nil {
+		return false, err
+	}
+
+	result, err := pg.Exec(`insert into profiles.verification_codes (key,value,expires_at) values ($1,$2,now()+make_interval(secs => $3))
+on conflict (key) do update set value=excluded.value,expires_at=excluded.expires_at where verification_codes.expires_at<=now()`, key, value, ttl.Seconds())
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (s *PostgresCodeStore) CompareAndSwap(key string, old, new []byte, ttl time.Duration) (bool, error) {
+	pg := db.Conn()
+
+	var result sql.Result
+	var err error
+	if new == nil {
+		result, err = pg.Exec("delete from profiles.verification_codes where key=$1 and value=$2 and expires_at>now()", key, old)
+	} else {
+		result, err = pg.Exec(`update profiles.verification_codes set value=$3,expires_at=now()+make_interval(secs => $4)
+where key=$1 and value=$2 and expires_at>now()`, key, old, new, ttl.Seconds())
+	}
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (s *PostgresCodeStore) Delete(key string) error {
+	pg := db.Conn()
+	_, err := pg.Exec("delete from profiles.verification_codes where key=$1", key)
+	return err
+}
@@ -0,0 +1,43 @@
+package export_service
+
+import (
+	car_services_service "odo24_mobile_backend/api/services/car_services"
+	cars_service "odo24_mobile_backend/api/services/cars"
+	groups_service "odo24_mobile_backend/api/services/groups"
+	"time"
+)
+
+type ManifestModel struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ProfileModel struct {
+	UserID      uint64     `json:"user_id"`
+	Email       string     `json:"email"`
+	OAuth       bool       `json:"oauth"`
+	LastLoginDt *time.Time `json:"last_login_dt"`
+}
+
+// ServiceRecordModel запись сервисной книжки с привязкой к авто и группе
+type ServiceRecordModel struct {
+	CarID   uint64 `json:"car_id"`
+	GroupID uint64 `json:"group_id"`
+	car_services_service.CarServiceModel
+}
+
+type ExportDataModel struct {
+	Manifest ManifestModel               `json:"manifest"`
+	Profile  ProfileModel                `json:"profile"`
+	Cars     []cars_service.CarModel     `json:"cars"`
+	Groups   []groups_service.GroupModel `json:"groups"`
+	Services []ServiceRecordModel        `json:"services"`
+}
+
+type ExportJobModel struct {
+	ExportID   string     `json:"export_id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
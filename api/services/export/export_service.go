@@ -0,0 +1,474 @@
+package export_service
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	car_services_service "odo24_mobile_backend/api/services/car_services"
+	cars_service "odo24_mobile_backend/api/services/cars"
+	groups_service "odo24_mobile_backend/api/services/groups"
+	"odo24_mobile_backend/db"
+	"odo24_mobile_backend/sendmail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ExportFormat  = "odo24-export"
+	ExportVersion = 1
+
+	StatusPending = "pending"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+
+	exportTTL = time.Hour * 72
+	// jobTimeout после него незавершенная выгрузка считается брошенной (например, при перезапуске)
+	jobTimeout = time.Minute * 30
+)
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportNotReady = errors.New("export not ready")
+)
+
+type ExportService struct {
+	cars        *cars_service.CarsService
+	groups      *groups_service.GroupsService
+	carServices *car_services_service.CarServicesService
+	dir         string
+}
+
+// NewExportService dir - каталог готовых архивов фоновой выгрузки
+func NewExportService(cars *cars_service.CarsService, groups *groups_service.GroupsService, carServices *car_services_service.CarServicesService, dir string) *ExportService {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "odo24_exports")
+	}
+	return &ExportService{
+		cars:        cars,
+		groups:      groups,
+		carServices: carServices,
+		dir:         dir,
+	}
+}
+
+// Collect все данные пользователя в формате моделей API
+func (srv *ExportService) Collect(userID uint64) (*ExportDataModel, error) {
+	data := ExportDataModel{
+		Manifest: ManifestModel{
+			Format:    ExportFormat,
+			Version:   ExportVersion,
+			CreatedAt: time.Now().UTC(),
+		},
+		Cars:     []cars_service.CarModel{},
+		Groups:   []groups_service.GroupModel{},
+		Services: []ServiceRecordModel{},
+	}
+
+	pg := db.Conn()
+	var lastLoginDt sql.NullTime
+	err := pg.QueryRow("select u.user_id,u.login,u.oauth,u.last_login_dt from profiles.users u where u.user_id=$1", userID).Scan(&data.Profile.UserID, &data.Profile.Email, &data.Profile.OAuth, &lastLoginDt)
+	if err != nil {
+		return nil, err
+	}
+	if lastLoginDt.Valid {
+		data.Profile.LastLoginDt = &lastLoginDt.Time
+	}
+
+	cars, err := srv.cars.GetCarsByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	data.Cars = append(data.Cars, cars...)
+
+	groups, err := srv.groups.GetGroupsByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	data.Groups = append(data.Groups, groups...)
+
+	for _, car := range cars {
+		if car.ServicesTotal == 0 {
+			continue
+		}
+		for _, group := range groups {
+			services, err := srv.carServices.GetServices(car.CarID, group.GroupID)
+			if err != nil {
+				return nil, err
+			}
+			for _, service := range services {
+				data.Services = append(data.Services, ServiceRecordModel{
+					CarID:           car.CarID,
+					GroupID:         group.GroupID,
+					CarServiceModel: service,
+				})
+			}
+		}
+	}
+
+	return &data, nil
+}
+
+// Write ZIP архив с данными пользователя
+func (srv *ExportService) Write(userID uint64, w io.Writer) error {
+	data, err := srv.Collect(userID)
+	if err != nil {
+		return err
+	}
+
+	return WriteArchive(data, w)
+}
+
+// WriteArchive ZIP архив: manifest.json и по JSON и CSV файлу на каждую сущность
+func WriteArchive(data *ExportDataModel, w io.Writer) error {
+	var err error
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name  string
+		value interface{}
+	}{
+		{"manifest.json", data.Manifest},
+		{"profile.json", data.Profile},
+		{"cars.json", data.Cars},
+		{"groups.json", data.Groups},
+		{"services.json", data.Services},
+	}
+	for _, file := range files {
+		err = writeJSON(archive, file.name, file.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	tables := []struct {
+		name string
+		rows [][]string
+	}{
+		{"profile.csv", profileRows(data.Profile)},
+		{"cars.csv", carsRows(data.Cars)},
+		{"groups.csv", groupsRows(data.Groups)},
+		{"services.csv", servicesRows(data.Services)},
+	}
+	for _, table := range tables {
+		err = writeCSV(archive, table.name, table.rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+/*
+StartAsync фоновая выгрузка, по готовности пользователю уходит письмо.
+Пока предыдущая выгрузка пользователя не завершена, возвращается она
+*/
+func (srv *ExportService) StartAsync(userID uint64) (*ExportJobModel, error) {
+	pg := db.Conn()
+
+	err := failStaleJobs(pg, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := scanJob(pg.QueryRow(`SELECT e.export_id,e.status,e.created_at,e.finished_at FROM profiles.exports e
+		WHERE e.user_id=$1 AND e.status=$2 ORDER BY e.created_at DESC LIMIT 1`, userID, StatusPending))
+	if err == nil {
+		return job, nil
+	}
+	if !errors.Is(err, ErrExportNotFound) {
+		return nil, err
+	}
+
+	err = os.MkdirAll(srv.dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+
+	exportID := uuid.New().String()
+	job, err = scanJob(pg.QueryRow(`INSERT INTO profiles.exports (export_id,user_id,status) VALUES ($1,$2,$3)
+		RETURNING export_id,status,created_at,finished_at`, exportID, userID, StatusPending))
+	if err != nil {
+		return nil, err
+	}
+
+	go srv.runJob(userID, exportID)
+
+	return job, nil
+}
+
+func (srv *ExportService) runJob(userID uint64, exportID string) {
+	pg := db.Conn()
+
+	err := srv.writeFile(userID, srv.filePath(exportID))
+	if err != nil {
+		log.Printf("export %s error: %v", exportID, err)
+		_, err = pg.Exec("UPDATE profiles.exports SET status=$1,error=$2,finished_at=now() WHERE export_id=$3", StatusFailed, err.Error(), exportID)
+		if err != nil {
+			log.Printf("export %s update status error: %v", exportID, err)
+		}
+		return
+	}
+
+	_, err = pg.Exec("UPDATE profiles.exports SET status=$1,finished_at=now() WHERE export_id=$2", StatusReady, exportID)
+	if err != nil {
+		log.Printf("export %s update status error: %v", exportID, err)
+		return
+	}
+
+	var email string
+	err = pg.QueryRow("select u.login from profiles.users u where u.user_id=$1", userID).Scan(&email)
+	if err != nil {
+		log.Printf("export %s notify error: %v", exportID, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["expires_days"] = int(exportTTL.Hours() / 24)
+
+	err = sendmail.Enqueue(userID, email, sendmail.TypeExportReady, data)
+	if err != nil {
+		log.Printf("export %s notify error: %v", exportID, err)
+	}
+}
+
+func (srv *ExportService) writeFile(userID uint64, path string) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	err = srv.Write(userID, file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// GetJob состояние фоновой выгрузки пользователя
+func (srv *ExportService) GetJob(userID uint64, exportID string) (*ExportJobModel, error) {
+	if _, err := uuid.Parse(exportID); err != nil {
+		return nil, ErrExportNotFound
+	}
+
+	pg := db.Conn()
+	return scanJob(pg.QueryRow(`SELECT e.export_id,e.status,e.created_at,e.finished_at FROM profiles.exports e
+		WHERE e.export_id=$1 AND e.user_id=$2`, exportID, userID))
+}
+
+// OpenJobFile готовый архив фоновой выгрузки, закрывает вызывающий
+func (srv *ExportService) OpenJobFile(userID uint64, exportID string) (*os.File, error) {
+	job, err := srv.GetJob(userID, exportID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != StatusReady {
+		return nil, ErrExportNotReady
+	}
+
+	file, err := os.Open(srv.filePath(job.ExportID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+/*
+CleanupExpired удаление выгрузок старше exportTTL.
+Файлы удаляются по времени изменения, в том числе файлы удаленных аккаунтов
+*/
+func (srv *ExportService) CleanupExpired() error {
+	expired := time.Now().Add(-exportTTL)
+
+	pg := db.Conn()
+	_, err := pg.Exec("DELETE FROM profiles.exports WHERE created_at<$1", expired)
+	if err != nil {
+		return err
+	}
+
+	err = failStaleJobs(pg, 0)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(srv.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || info.ModTime().After(expired) {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(srv.dir, entry.Name()))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("remove export %s error: %v", entry.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// RunCleanup периодический запуск CleanupExpired, блокирует вызывающую горутину
+func (srv *ExportService) RunCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := srv.CleanupExpired()
+		if err != nil {
+			log.Printf("cleanup exports error: %v", err)
+		}
+	}
+}
+
+// failStaleJobs перевод брошенных выгрузок в failed, userID 0 - всех пользователей
+func failStaleJobs(pg *sql.DB, userID uint64) error {
+	_, err := pg.Exec(`UPDATE profiles.exports SET status=$1,error=$2,finished_at=now()
+		WHERE status=$3 AND created_at<$4 AND ($5=0 OR user_id=$5)`,
+		StatusFailed, "interrupted", StatusPending, time.Now().Add(-jobTimeout), userID)
+	return err
+}
+
+func (srv *ExportService) filePath(exportID string) string {
+	return filepath.Join(srv.dir, exportID+".zip")
+}
+
+func scanJob(row *sql.Row) (*ExportJobModel, error) {
+	var job ExportJobModel
+	var finishedAt sql.NullTime
+	err := row.Scan(&job.ExportID, &job.Status, &job.CreatedAt, &finishedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return &job, nil
+}
+
+func writeJSON(archive *zip.Writer, name string, value interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func writeCSV(archive *zip.Writer, name string, rows [][]string) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	// BOM, чтобы Excel открывал кириллицу в UTF-8
+	_, err = w.Write([]byte("\xef\xbb\xbf"))
+	if err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(w)
+	err = csvWriter.WriteAll(rows)
+	if err != nil {
+		return err
+	}
+	return csvWriter.Error()
+}
+
+func profileRows(profile ProfileModel) [][]string {
+	lastLoginDt := ""
+	if profile.LastLoginDt != nil {
+		lastLoginDt = profile.LastLoginDt.Format(time.RFC3339)
+	}
+	return [][]string{
+		{"user_id", "email", "oauth", "last_login_dt"},
+		{strconv.FormatUint(profile.UserID, 10), profile.Email, strconv.FormatBool(profile.OAuth), lastLoginDt},
+	}
+}
+
+func carsRows(cars []cars_service.CarModel) [][]string {
+	rows := [][]string{{"car_id", "name", "odo", "avatar", "services_total"}}
+	for _, car := range cars {
+		rows = append(rows, []string{
+			strconv.FormatUint(car.CarID, 10),
+			car.Name,
+			fmt.Sprint(car.Odo),
+			strconv.FormatBool(car.Avatar),
+			fmt.Sprint(car.ServicesTotal),
+		})
+	}
+	return rows
+}
+
+func groupsRows(groups []groups_service.GroupModel) [][]string {
+	rows := [][]string{{"group_id", "name", "sort"}}
+	for _, group := range groups {
+		rows = append(rows, []string{
+			strconv.FormatUint(group.GroupID, 10),
+			group.Name,
+			fmt.Sprint(group.Sort),
+		})
+	}
+	return rows
+}
+
+func servicesRows(services []ServiceRecordModel) [][]string {
+	rows := [][]string{{"service_id", "car_id", "group_id", "dt", "odo", "next_distance", "next_months", "price", "description"}}
+	for _, service := range services {
+		rows = append(rows, []string{
+			strconv.FormatUint(service.ServiceID, 10),
+			strconv.FormatUint(service.CarID, 10),
+			strconv.FormatUint(service.GroupID, 10),
+			service.Dt,
+			formatUint32(service.Odo),
+			formatUint32(service.NextDistance),
+			formatUint32(service.NextMonths),
+			formatUint32(service.Price),
+			formatString(service.Description),
+		})
+	}
+	return rows
+}
+
+func formatUint32(value *uint32) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(*value)
+}
+
+func formatString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -1,6 +1,7 @@
 package groups_service
 
 import (
+	"database/sql"
 	"fmt"
 	"odo24_mobile_backend/api/services"
 	"odo24_mobile_backend/db"
@@ -66,8 +67,15 @@ func (srv *GroupsService) GetGroupsByIDs(groupIDs []uint64) ([]GroupModel, error
 }
 
 func (srv *GroupsService) Create(userID uint64, groupBody GroupCreateModel) (*GroupModel, error) {
-	pg := db.Conn()
+	return srv.create(db.Conn(), userID, groupBody)
+}
+
+// CreateTx создание группы в транзакции вызывающего
+func (srv *GroupsService) CreateTx(tx *sql.Tx, userID uint64, groupBody GroupCreateModel) (*GroupModel, error) {
+	return srv.create(tx, userID, groupBody)
+}
 
+func (srv *GroupsService) create(pg services.Querier, userID uint64, groupBody GroupCreateModel) (*GroupModel, error) {
 	var sort uint32 = 0
 	row := pg.QueryRow(`select max(sg.sort) from service_book.service_groups sg where sg.user_id=$1`, userID)
 	if row != nil {
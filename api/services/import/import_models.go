@@ -0,0 +1,55 @@
+package import_service
+
+const (
+	RowStatusCreated  = "created"
+	RowStatusSkipped  = "skipped"
+	RowStatusRejected = "rejected"
+)
+
+// importFile разобранный файл. Cars и Groups - авто и группы выгрузки, создаются даже без записей
+type importFile struct {
+	Format string
+	Rows   []importRow
+	Cars   []importCar
+	Groups []string
+}
+
+type importCar struct {
+	Name string
+	Odo  uint32
+}
+
+// importRow запись сервисной книжки из файла, авто и группа - по названию
+type importRow struct {
+	Line         int
+	Car          string
+	Group        string
+	Dt           string
+	Odo          *uint32
+	NextDistance *uint32
+	NextMonths   *uint32
+	Price        *uint32
+	Description  *string
+	// CarOdo пробег авто из выгрузки, для новых авто
+	CarOdo uint32
+	// Err ошибка разбора строки, такая строка отклоняется
+	Err string
+}
+
+type ImportRowReportModel struct {
+	Line      int    `json:"line"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
+	ServiceID uint64 `json:"service_id,omitempty"`
+}
+
+type ImportReportModel struct {
+	Format        string                 `json:"format"`
+	DryRun        bool                   `json:"dry_run"`
+	Created       int                    `json:"created"`
+	Skipped       int                    `json:"skipped"`
+	Rejected      int                    `json:"rejected"`
+	CarsCreated   []string               `json:"cars_created"`
+	GroupsCreated []string               `json:"groups_created"`
+	Rows          []ImportRowReportModel `json:"rows"`
+}
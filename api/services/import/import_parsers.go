@@ -0,0 +1,374 @@
+package import_service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	export_service "odo24_mobile_backend/api/services/export"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FormatExportZip  = "export_zip"
+	FormatExportJSON = "export_json"
+	FormatCSV        = "csv"
+
+	maxRows = 10000
+	// maxZipEntrySize предел распакованного файла архива, защита от zip-бомб
+	maxZipEntrySize = 32 << 20
+)
+
+var (
+	ErrEmptyFile     = errors.New("empty file")
+	ErrUnknownFormat = errors.New("unknown import format")
+	ErrTooManyRows   = fmt.Errorf("too many rows, max %d", maxRows)
+	ErrEntryTooLarge = fmt.Errorf("archive entry too large, max %d bytes", maxZipEntrySize)
+)
+
+var csvColumns = []string{"car", "group", "date", "odo", "next_distance", "price", "description", "next_months"}
+
+var csvColumnAliases = map[string]string{
+	"dt": "date",
+}
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"02.01.2006",
+	"2.1.2006",
+	"02/01/2006",
+	time.RFC3339,
+}
+
+// parse определение формата по содержимому: ZIP и JSON - наша выгрузка, иначе CSV
+func parse(body []byte) (*importFile, error) {
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, ErrEmptyFile
+	}
+
+	if bytes.HasPrefix(body, []byte("PK\x03\x04")) {
+		return parseExportZip(body)
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		var data export_service.ExportDataModel
+		err := json.Unmarshal(body, &data)
+		if err != nil {
+			return nil, err
+		}
+		return exportFile(FormatExportJSON, &data)
+	}
+
+	rows, err := parseCSV(body)
+	if err != nil {
+		return nil, err
+	}
+	return &importFile{Format: FormatCSV, Rows: rows}, nil
+}
+
+func parseExportZip(body []byte) (*importFile, error) {
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+
+	var data export_service.ExportDataModel
+	files := map[string]interface{}{
+		"manifest.json": &data.Manifest,
+		"cars.json":     &data.Cars,
+		"groups.json":   &data.Groups,
+		"services.json": &data.Services,
+	}
+	for name, value := range files {
+		err := readZipJSON(archive, name, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exportFile(FormatExportZip, &data)
+}
+
+func readZipJSON(archive *zip.Reader, name string, value interface{}) error {
+	file, err := archive.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, err)
+	}
+	defer file.Close()
+
+	// размер из заголовка архива может быть подделан, поэтому чтение тоже ограничено
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() > maxZipEntrySize {
+		return ErrEntryTooLarge
+	}
+
+	body, err := io.ReadAll(io.LimitReader(file, maxZipEntrySize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxZipEntrySize {
+		return ErrEntryTooLarge
+	}
+
+	return json.Unmarshal(body, value)
+}
+
+// exportFile записи выгрузки с заменой идентификаторов авто и групп на названия
+func exportFile(format string, data *export_service.ExportDataModel) (*importFile, error) {
+	if data.Manifest.Format != export_service.ExportFormat {
+		return nil, ErrUnknownFormat
+	}
+	if len(data.Services) > maxRows {
+		return nil, ErrTooManyRows
+	}
+
+	file := importFile{
+		Format: format,
+		Rows:   make([]importRow, 0, len(data.Services)),
+	}
+
+	cars := make(map[uint64]string, len(data.Cars))
+	carsOdo := make(map[uint64]uint32, len(data.Cars))
+	for _, car := range data.Cars {
+		if car.Odo > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: car odo %d out of range", ErrUnknownFormat, car.Odo)
+		}
+		cars[car.CarID] = car.Name
+		carsOdo[car.CarID] = car.Odo
+		file.Cars = append(file.Cars, importCar{Name: car.Name, Odo: car.Odo})
+	}
+	groups := make(map[uint64]string, len(data.Groups))
+	for _, group := range data.Groups {
+		groups[group.GroupID] = group.Name
+		file.Groups = append(file.Groups, group.Name)
+	}
+
+	for i, service := range data.Services {
+		row := importRow{
+			Line:         i + 1,
+			Car:          cars[service.CarID],
+			Group:        groups[service.GroupID],
+			Odo:          service.Odo,
+			NextDistance: service.NextDistance,
+			NextMonths:   service.NextMonths,
+			Price:        service.Price,
+			Description:  service.Description,
+			CarOdo:       carsOdo[service.CarID],
+		}
+
+		dt, err := parseDate(service.Dt)
+		if err != nil {
+			row.Err = err.Error()
+		}
+		row.Dt = dt
+
+		for _, number := range []*uint32{row.Odo, row.NextDistance, row.Price} {
+			if err := checkNumber(number); err != nil && row.Err == "" {
+				row.Err = err.Error()
+			}
+		}
+
+		file.Rows = append(file.Rows, row)
+	}
+
+	return &file, nil
+}
+
+/*
+parseCSV CSV с колонками car, group, date, odo, next_distance, price, description, next_months.
+Первая строка с названиями колонок задает их порядок, без нее колонки идут в этом порядке.
+Разделитель - запятая или точка с запятой
+*/
+func parseCSV(body []byte) ([]importRow, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.Comma = detectDelimiter(body)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	columns := make(map[string]int, len(csvColumns))
+	for i, name := range csvColumns {
+		columns[name] = i
+	}
+
+	var rows []importRow
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if line == 1 && isHeader(record) {
+			columns = headerColumns(record)
+			continue
+		}
+		if isEmptyRecord(record) {
+			continue
+		}
+		if len(rows) >= maxRows {
+			return nil, ErrTooManyRows
+		}
+
+		rows = append(rows, csvRow(line, record, columns))
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrEmptyFile
+	}
+	return rows, nil
+}
+
+func csvRow(line int, record []string, columns map[string]int) importRow {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	row := importRow{
+		Line:  line,
+		Car:   field("car"),
+		Group: field("group"),
+	}
+
+	var errs []string
+
+	dt, err := parseDate(field("date"))
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	row.Dt = dt
+
+	numbers := []struct {
+		name  string
+		value **uint32
+	}{
+		{"odo", &row.Odo},
+		{"next_distance", &row.NextDistance},
+		{"next_months", &row.NextMonths},
+		{"price", &row.Price},
+	}
+	for _, number := range numbers {
+		value, err := parseNumber(field(number.name))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", number.name, err))
+			continue
+		}
+		*number.value = value
+	}
+
+	if description := field("description"); description != "" {
+		row.Description = &description
+	}
+	if row.Odo != nil {
+		row.CarOdo = *row.Odo
+	}
+
+	row.Err = strings.Join(errs, "; ")
+	return row
+}
+
+func detectDelimiter(body []byte) rune {
+	firstLine := body
+	if i := bytes.IndexByte(body, '\n'); i >= 0 {
+		firstLine = body[:i]
+	}
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		return ';'
+	}
+	return ','
+}
+
+func isHeader(record []string) bool {
+	for _, cell := range record {
+		if _, ok := headerName(cell); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func headerColumns(record []string) map[string]int {
+	columns := make(map[string]int, len(record))
+	for i, cell := range record {
+		if name, ok := headerName(cell); ok {
+			columns[name] = i
+		}
+	}
+	return columns
+}
+
+func headerName(cell string) (string, bool) {
+	name := strings.ToLower(strings.TrimSpace(cell))
+	if alias, ok := csvColumnAliases[name]; ok {
+		name = alias
+	}
+	for _, column := range csvColumns {
+		if column == name {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func isEmptyRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDate дата в формате 2006-01-02, в котором ее принимает API
+func parseDate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", errors.New("date is required")
+	}
+	for _, layout := range dateLayouts {
+		dt, err := time.Parse(layout, value)
+		if err == nil {
+			return dt.Format("2006-01-02"), nil
+		}
+	}
+	return "", fmt.Errorf("invalid date %q", value)
+}
+
+// parseNumber пустое значение - nil, пробелы разрядов допускаются
+func parseNumber(value string) (*uint32, error) {
+	value = strings.NewReplacer(" ", "", " ", "").Replace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	number, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", value)
+	}
+	result := uint32(number)
+	return &result, checkNumber(&result)
+}
+
+// checkNumber колонки odo, next_distance и price - int4, большее значение оборвало бы всю транзакцию импорта
+func checkNumber(value *uint32) error {
+	if value != nil && *value > math.MaxInt32 {
+		return fmt.Errorf("number %d out of range, max %d", *value, math.MaxInt32)
+	}
+	return nil
+}
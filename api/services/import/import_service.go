@@ -0,0 +1,277 @@
+package import_service
+
+import (
+	"database/sql"
+	"fmt"
+	car_services_service "odo24_mobile_backend/api/services/car_services"
+	cars_service "odo24_mobile_backend/api/services/cars"
+	groups_service "odo24_mobile_backend/api/services/groups"
+	"odo24_mobile_backend/db"
+	"strings"
+)
+
+type ImportService struct {
+	cars        *cars_service.CarsService
+	groups      *groups_service.GroupsService
+	carServices *car_services_service.CarServicesService
+}
+
+func NewImportService(cars *cars_service.CarsService, groups *groups_service.GroupsService, carServices *car_services_service.CarServicesService) *ImportService {
+	return &ImportService{
+		cars:        cars,
+		groups:      groups,
+		carServices: carServices,
+	}
+}
+
+// importState авто и группы пользователя по названию на время одного импорта
+type importState struct {
+	tx     *sql.Tx
+	userID uint64
+	report *ImportReportModel
+	cars   map[string]uint64
+	groups map[string]uint64
+	seen   map[string]struct{}
+}
+
+/*
+Import загрузка записей сервисной книжки одной транзакцией.
+Недостающие авто и группы создаются, записи, совпадающие с уже существующими, пропускаются,
+строки с ошибками отклоняются и не прерывают импорт. При dryRun транзакция откатывается
+*/
+func (srv *ImportService) Import(userID uint64, body []byte, dryRun bool) (*ImportReportModel, error) {
+	file, err := parse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	state := importState{
+		userID: userID,
+		report: &ImportReportModel{
+			Format:        file.Format,
+			DryRun:        dryRun,
+			CarsCreated:   []string{},
+			GroupsCreated: []string{},
+			Rows:          make([]ImportRowReportModel, 0, len(file.Rows)),
+		},
+		cars:   make(map[string]uint64),
+		groups: make(map[string]uint64),
+		seen:   make(map[string]struct{}),
+	}
+
+	cars, err := srv.cars.GetCarsByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, car := range cars {
+		state.cars[nameKey(car.Name)] = car.CarID
+	}
+
+	groups, err := srv.groups.GetGroupsByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		state.groups[nameKey(group.Name)] = group.GroupID
+	}
+
+	pg := db.Conn()
+	state.tx, err = pg.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer state.tx.Rollback()
+
+	for _, car := range file.Cars {
+		_, err = srv.ensureCar(&state, car.Name, car.Odo)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, group := range file.Groups {
+		_, err = srv.ensureGroup(&state, group)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	carsOdo := maxCarsOdo(file.Rows)
+	for _, row := range file.Rows {
+		rowReport, err := srv.importRow(&state, row, carsOdo[nameKey(row.Car)])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", row.Line, err)
+		}
+
+		switch rowReport.Status {
+		case RowStatusCreated:
+			state.report.Created++
+		case RowStatusSkipped:
+			state.report.Skipped++
+		case RowStatusRejected:
+			state.report.Rejected++
+		}
+		state.report.Rows = append(state.report.Rows, rowReport)
+	}
+
+	if !dryRun {
+		err = state.tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return state.report, nil
+}
+
+func (srv *ImportService) importRow(state *importState, row importRow, carOdo uint32) (ImportRowReportModel, error) {
+	report := ImportRowReportModel{
+		Line: row.Line,
+	}
+
+	reject := func(reason string) (ImportRowReportModel, error) {
+		report.Status = RowStatusRejected
+		report.Reason = reason
+		return report, nil
+	}
+
+	if row.Err != "" {
+		return reject(row.Err)
+	}
+	if strings.TrimSpace(row.Car) == "" {
+		return reject("car is required")
+	}
+	if strings.TrimSpace(row.Group) == "" {
+		return reject("group is required")
+	}
+
+	carID, err := srv.ensureCar(state, row.Car, carOdo)
+	if err != nil {
+		return report, err
+	}
+	groupID, err := srv.ensureGroup(state, row.Group)
+	if err != nil {
+		return report, err
+	}
+
+	key := recordKey(carID, groupID, row)
+	if _, ok := state.seen[key]; ok {
+		report.Status = RowStatusSkipped
+		report.Reason = "duplicate in file"
+		return report, nil
+	}
+	state.seen[key] = struct{}{}
+
+	exists, err := recordExists(state.tx, carID, groupID, row)
+	if err != nil {
+		return report, err
+	}
+	if exists {
+		report.Status = RowStatusSkipped
+		report.Reason = "already exists"
+		return report, nil
+	}
+
+	model, err := srv.carServices.CreateTx(state.tx, car_services_service.CarServiceCreateModel{
+		CarID:        carID,
+		GroupID:      groupID,
+		Odo:          row.Odo,
+		NextDistance: row.NextDistance,
+		NextMonths:   row.NextMonths,
+		Dt:           row.Dt,
+		Description:  row.Description,
+		Price:        row.Price,
+	})
+	if err != nil {
+		return report, err
+	}
+
+	report.Status = RowStatusCreated
+	report.ServiceID = model.ServiceID
+	return report, nil
+}
+
+func (srv *ImportService) ensureCar(state *importState, name string, odo uint32) (uint64, error) {
+	name = strings.TrimSpace(name)
+	if carID, ok := state.cars[nameKey(name)]; ok {
+		return carID, nil
+	}
+
+	car, err := srv.cars.CreateTx(state.tx, state.userID, cars_service.CarCreateModel{
+		Name: name,
+		Odo:  odo,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	state.cars[nameKey(name)] = car.CarID
+	state.report.CarsCreated = append(state.report.CarsCreated, name)
+	return car.CarID, nil
+}
+
+func (srv *ImportService) ensureGroup(state *importState, name string) (uint64, error) {
+	name = strings.TrimSpace(name)
+	if groupID, ok := state.groups[nameKey(name)]; ok {
+		return groupID, nil
+	}
+
+	group, err := srv.groups.CreateTx(state.tx, state.userID, groups_service.GroupCreateModel{
+		Name: name,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	state.groups[nameKey(name)] = group.GroupID
+	state.report.GroupsCreated = append(state.report.GroupsCreated, name)
+	return group.GroupID, nil
+}
+
+// recordExists такая же запись уже есть в сервисной книжке
+func recordExists(tx *sql.Tx, carID, groupID uint64, row importRow) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM service_book.services s
+		WHERE s.car_id=$1 AND s.group_id=$2 AND s.dt=$3::date
+		AND s.odo IS NOT DISTINCT FROM $4::integer
+		AND s.next_distance IS NOT DISTINCT FROM $5::integer
+		AND s.price IS NOT DISTINCT FROM $6::integer
+		AND s.description IS NOT DISTINCT FROM $7::text
+		AND s.next_months IS NOT DISTINCT FROM $8::integer)`,
+		carID, groupID, row.Dt, row.Odo, row.NextDistance, row.Price, row.Description, row.NextMonths).Scan(&exists)
+	return exists, err
+}
+
+// maxCarsOdo пробег новых авто из CSV - наибольший пробег в их записях
+func maxCarsOdo(rows []importRow) map[string]uint32 {
+	result := make(map[string]uint32)
+	for _, row := range rows {
+		key := nameKey(row.Car)
+		if row.CarOdo > result[key] {
+			result[key] = row.CarOdo
+		}
+	}
+	return result
+}
+
+func recordKey(carID, groupID uint64, row importRow) string {
+	return fmt.Sprintf("%d|%d|%s|%s|%s|%s|%s|%s", carID, groupID, row.Dt,
+		optionalNumber(row.Odo), optionalNumber(row.NextDistance), optionalNumber(row.NextMonths), optionalNumber(row.Price), optionalString(row.Description))
+}
+
+func optionalNumber(value *uint32) string {
+	if value == nil {
+		return "-"
+	}
+	return fmt.Sprintf("=%d", *value)
+}
+
+func optionalString(value *string) string {
+	if value == nil {
+		return "-"
+	}
+	return "=" + *value
+}
+
+func nameKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
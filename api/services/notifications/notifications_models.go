@@ -0,0 +1,41 @@
+package notifications_service
+
+import "time"
+
+// платформы устройств
+const (
+	PlatformAndroid = "android"
+	PlatformIOS     = "ios"
+)
+
+// виды уведомлений, для каждого вида своя настройка
+const (
+	KindReminder = "reminder"
+	KindTest     = "test"
+)
+
+type DeviceModel struct {
+	DeviceID  uint64    `json:"device_id"`
+	Platform  string    `json:"platform"`
+	Token     string    `json:"-"`
+	Current   bool      `json:"current"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type PreferencesModel struct {
+	PushEnabled   bool `json:"push_enabled"`
+	ReminderPush  bool `json:"reminder_push"`
+	ReminderEmail bool `json:"reminder_email"`
+}
+
+type NotifyResultModel struct {
+	Delivered int `json:"delivered"`
+}
+
+type PushMessage struct {
+	Kind  string
+	Title string
+	Body  string
+	Data  map[string]string
+}
@@ -0,0 +1,227 @@
+package notifications_service
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"odo24_mobile_backend/db"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 3
+	retryBaseDelay     = time.Millisecond * 500
+	// staleDeviceTTL устройства, не обновлявшие токен дольше, считаются неактивными
+	staleDeviceTTL = time.Hour * 24 * 270
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrDeviceNotFound  = errors.New("device not found")
+	ErrUnknownPlatform = errors.New("unknown platform")
+)
+
+type NotificationsService struct {
+	provider    Provider
+	maxAttempts int
+}
+
+func NewNotificationsService(provider Provider, maxAttempts int) *NotificationsService {
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	return &NotificationsService{
+		provider:    provider,
+		maxAttempts: maxAttempts,
+	}
+}
+
+/*
+RegisterDevice привязка токена устройства к текущей сессии пользователя.
+Повторная регистрация токена переносит его в текущую сессию, завершение сессии удаляет устройство
+*/
+func (srv *NotificationsService) RegisterDevice(userID uint64, tokenUUID, platform, token string) error {
+	if platform != PlatformAndroid && platform != PlatformIOS {
+		return ErrUnknownPlatform
+	}
+
+	pg := db.Conn()
+
+	result, err := pg.Exec(`INSERT INTO profiles.push_devices (user_id,session_id,platform,token)
+		SELECT s.user_id,s.session_id,$3,$4 FROM profiles.sessions s WHERE s.user_id=$1 AND s.token_uuid=$2
+		ON CONFLICT (token) DO UPDATE SET user_id=excluded.user_id,session_id=excluded.session_id,platform=excluded.platform,updated_at=now()`,
+		userID, tokenUUID, platform, token)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// UnregisterDevice удаление токена устройства пользователя
+func (srv *NotificationsService) UnregisterDevice(userID uint64, token string) error {
+	pg := db.Conn()
+
+	result, err := pg.Exec(`DELETE FROM profiles.push_devices WHERE user_id=$1 AND token=$2`, userID, token)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+// GetDevices устройства пользователя, current - устройство текущей сессии
+func (srv *NotificationsService) GetDevices(userID uint64, currentTokenUUID string) ([]DeviceModel, error) {
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT d.device_id,d.platform,d.token,s.token_uuid::text=$2,d.created_at,d.updated_at
+		FROM profiles.push_devices d
+		INNER JOIN profiles.sessions s ON s.session_id=d.session_id
+		WHERE d.user_id=$1
+		ORDER BY d.updated_at DESC`, userID, currentTokenUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	devices := []DeviceModel{}
+	for rows.Next() {
+		var device DeviceModel
+		err := rows.Scan(&device.DeviceID, &device.Platform, &device.Token, &device.Current, &device.CreatedAt, &device.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
+// GetPreferences настройки уведомлений, по умолчанию все включено
+func (srv *NotificationsService) GetPreferences(userID uint64) (*PreferencesModel, error) {
+	pg := db.Conn()
+
+	prefs := PreferencesModel{
+		PushEnabled:   true,
+		ReminderPush:  true,
+		ReminderEmail: true,
+	}
+	err := pg.QueryRow(`SELECT p.push_enabled,p.reminder_push,p.reminder_email FROM profiles.notification_preferences p WHERE p.user_id=$1`, userID).
+		Scan(&prefs.PushEnabled, &prefs.ReminderPush, &prefs.ReminderEmail)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &prefs, nil
+}
+
+func (srv *NotificationsService) UpdatePreferences(userID uint64, prefs PreferencesModel) error {
+	pg := db.Conn()
+
+	_, err := pg.Exec(`INSERT INTO profiles.notification_preferences (user_id,push_enabled,reminder_push,reminder_email) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (user_id) DO UPDATE SET push_enabled=excluded.push_enabled,reminder_push=excluded.reminder_push,reminder_email=excluded.reminder_email,updated_at=now()`,
+		userID, prefs.PushEnabled, prefs.ReminderPush, prefs.ReminderEmail)
+	return err
+}
+
+/*
+NotifyUser отправка push на все устройства пользователя с учетом настроек, возвращает число доставленных.
+Временные ошибки провайдера повторяются с экспоненциальной задержкой,
+устройства с недействительным токеном удаляются
+*/
+func (srv *NotificationsService) NotifyUser(userID uint64, message PushMessage) (int, error) {
+	prefs, err := srv.GetPreferences(userID)
+	if err != nil {
+		return 0, err
+	}
+	if !prefs.PushEnabled || (message.Kind == KindReminder && !prefs.ReminderPush) {
+		return 0, nil
+	}
+
+	devices, err := srv.GetDevices(userID, "")
+	if err != nil {
+		return 0, err
+	}
+
+	var delivered int
+	var lastErr error
+	for _, device := range devices {
+		err := srv.sendWithRetry(device, message)
+		if err == nil {
+			delivered++
+			continue
+		}
+
+		if errors.Is(err, ErrInvalidToken) {
+			srv.deleteDevice(device.DeviceID)
+			continue
+		}
+		log.Printf("push to device_id=%d error: %v", device.DeviceID, err)
+		lastErr = err
+	}
+
+	if delivered == 0 && lastErr != nil {
+		return 0, lastErr
+	}
+	return delivered, nil
+}
+
+func (srv *NotificationsService) sendWithRetry(device DeviceModel, message PushMessage) error {
+	var err error
+	for attempt := 0; attempt < srv.maxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryBaseDelay << (attempt - 1))
+		}
+
+		err = srv.provider.Send(device, message)
+		if err == nil || !errors.Is(err, ErrPushUnavailable) {
+			return err
+		}
+	}
+	return err
+}
+
+func (srv *NotificationsService) deleteDevice(deviceID uint64) {
+	pg := db.Conn()
+
+	_, err := pg.Exec(`DELETE FROM profiles.push_devices WHERE device_id=$1`, deviceID)
+	if err != nil {
+		log.Printf("delete push device_id=%d error: %v", deviceID, err)
+	}
+}
+
+// CleanupStale удаление устройств, токен которых давно не обновлялся
+func (srv *NotificationsService) CleanupStale() error {
+	pg := db.Conn()
+
+	_, err := pg.Exec(`DELETE FROM profiles.push_devices WHERE updated_at<$1`, time.Now().Add(-staleDeviceTTL))
+	return err
+}
+
+// RunCleanup периодический запуск CleanupStale, блокирует вызывающую горутину
+func (srv *NotificationsService) RunCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := srv.CleanupStale()
+		if err != nil {
+			log.Printf("cleanup push devices error: %v", err)
+		}
+	}
+}
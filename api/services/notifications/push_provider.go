@@ -0,0 +1,173 @@
+package notifications_service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultPushTimeout = time.Second * 10
+
+var (
+	// ErrInvalidToken токен устройства больше не действителен, устройство нужно удалить
+	ErrInvalidToken = errors.New("push: invalid device token")
+	// ErrPushUnavailable временная ошибка провайдера, отправку можно повторить
+	ErrPushUnavailable = errors.New("push: provider unavailable")
+)
+
+// Provider отправка push уведомления на одно устройство
+type Provider interface {
+	Send(device DeviceModel, message PushMessage) error
+}
+
+/*
+ProviderOptions настройки HTTP провайдера.
+CredentialsFile - ключ сервисного аккаунта Google для FCM HTTP v1, access токен OAuth2 получается по нему.
+AccessToken - постоянный токен для шлюза или локальной заглушки, используется без CredentialsFile
+*/
+type ProviderOptions struct {
+	Endpoint        string
+	AccessToken     string
+	CredentialsFile string
+	Timeout         time.Duration
+}
+
+// NewProvider HTTP провайдер, при пустом endpoint - уведомления только пишутся в лог
+func NewProvider(options ProviderOptions) (Provider, error) {
+	if options.Endpoint == "" {
+		return NewLogProvider(), nil
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultPushTimeout
+	}
+	client := &http.Client{
+		Timeout: options.Timeout,
+	}
+
+	var tokens TokenSource = StaticTokenSource(options.AccessToken)
+	if options.CredentialsFile != "" {
+		var err error
+		tokens, err = NewServiceAccountTokenSource(options.CredentialsFile, client)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewHTTPProvider(options.Endpoint, tokens, client), nil
+}
+
+/*
+HTTPProvider отправка в формате FCM HTTP v1 ({"message": {...}}) на endpoint из настроек:
+напрямую в FCM, в шлюз к APNs с тем же форматом или в локальную заглушку.
+404, 410, UNREGISTERED и BadDeviceToken - ErrInvalidToken, 429 и 5xx - ErrPushUnavailable.
+Прочие ошибки 400 (INVALID_ARGUMENT) - ошибка запроса, устройство не удаляется
+*/
+type HTTPProvider struct {
+	endpoint string
+	tokens   TokenSource
+	client   *http.Client
+}
+
+func NewHTTPProvider(endpoint string, tokens TokenSource, client *http.Client) *HTTPProvider {
+	return &HTTPProvider{
+		endpoint: endpoint,
+		tokens:   tokens,
+		client:   client,
+	}
+}
+
+type httpPushRequest struct {
+	Message httpPushMessage `json:"message"`
+}
+
+type httpPushMessage struct {
+	Token        string            `json:"token"`
+	Notification httpNotification  `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+}
+
+type httpNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+func (p *HTTPProvider) Send(device DeviceModel, message PushMessage) error {
+	body, err := json.Marshal(httpPushRequest{
+		Message: httpPushMessage{
+			Token: device.Token,
+			Notification: httpNotification{
+				Title: message.Title,
+				Body:  message.Body,
+			},
+			Data: message.Data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := p.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPushUnavailable, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPushUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusGone:
+		return ErrInvalidToken
+	// 404 без причины - неверный endpoint или проект, а не токен: иначе удалились бы все устройства
+	case (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest) && isInvalidTokenResponse(respBody):
+		return ErrInvalidToken
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("%w: status=%d", ErrPushUnavailable, resp.StatusCode)
+	}
+
+	return fmt.Errorf("push response status=%d: %s", resp.StatusCode, respBody)
+}
+
+// isInvalidTokenResponse причина ошибки FCM (UNREGISTERED) или APNs (BadDeviceToken, Unregistered)
+func isInvalidTokenResponse(body []byte) bool {
+	text := string(body)
+	for _, reason := range []string{"UNREGISTERED", "BadDeviceToken", "Unregistered"} {
+		if strings.Contains(text, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// LogProvider уведомления только пишутся в лог, для разработки и запуска без провайдера
+type LogProvider struct{}
+
+func NewLogProvider() *LogProvider {
+	return &LogProvider{}
+}
+
+func (p *LogProvider) Send(device DeviceModel, message PushMessage) error {
+	log.Printf("push to device_id=%d (%s): %s - %s", device.DeviceID, device.Platform, message.Title, message.Body)
+	return nil
+}
@@ -0,0 +1,146 @@
+package notifications_service
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// pushStub локальная заглушка FCM: отвечает заданным статусом и сохраняет последний запрос
+type pushStub struct {
+	status        int
+	body          string
+	lastBody      []byte
+	authorization string
+}
+
+func (s *pushStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lastBody, _ = io.ReadAll(r.Body)
+	s.authorization = r.Header.Get("Authorization")
+	w.WriteHeader(s.status)
+	w.Write([]byte(s.body))
+}
+
+func TestHTTPProviderSend(t *testing.T) {
+	stub := &pushStub{}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	provider, err := NewProvider(ProviderOptions{Endpoint: server.URL, AccessToken: "stub-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := DeviceModel{DeviceID: 1, Platform: PlatformIOS, Token: "device-token"}
+	message := PushMessage{Title: "title", Body: "body"}
+
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   error
+	}{
+		{"ok", http.StatusOK, `{"name":"projects/p/messages/1"}`, nil},
+		{"fcm unregistered", http.StatusNotFound, `{"error":{"status":"NOT_FOUND","details":[{"errorCode":"UNREGISTERED"}]}}`, ErrInvalidToken},
+		{"apns unregistered", http.StatusGone, `{"reason":"Unregistered"}`, ErrInvalidToken},
+		{"apns bad token", http.StatusBadRequest, `{"reason":"BadDeviceToken"}`, ErrInvalidToken},
+		{"unavailable", http.StatusServiceUnavailable, ``, ErrPushUnavailable},
+		{"rate limit", http.StatusTooManyRequests, ``, ErrPushUnavailable},
+	}
+	for _, tc := range cases {
+		stub.status, stub.body = tc.status, tc.body
+		err := provider.Send(device, message)
+		if tc.want == nil && err != nil || tc.want != nil && !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	// ошибка запроса или настроек не должна приводить к удалению устройства
+	for _, tc := range []struct {
+		status int
+		body   string
+	}{
+		{http.StatusBadRequest, `{"error":{"status":"INVALID_ARGUMENT"}}`},
+		{http.StatusNotFound, `{"error":{"status":"NOT_FOUND","message":"Requested entity was not found."}}`},
+	} {
+		stub.status, stub.body = tc.status, tc.body
+		err = provider.Send(device, message)
+		if err == nil || errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrPushUnavailable) {
+			t.Errorf("status %d %s: got %v", tc.status, tc.body, err)
+		}
+	}
+
+	if stub.authorization != "Bearer stub-token" {
+		t.Errorf("authorization = %q", stub.authorization)
+	}
+
+	var request map[string]map[string]interface{}
+	err = json.Unmarshal(stub.lastBody, &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := request["message"]["platform"]; ok {
+		t.Errorf("message must not contain platform: %s", stub.lastBody)
+	}
+	if request["message"]["token"] != "device-token" {
+		t.Errorf("token = %v", request["message"]["token"])
+	}
+}
+
+func TestServiceAccountTokenSource(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	var requests atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || r.FormValue("assertion") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"short-lived","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	credentials, _ := json.Marshal(serviceAccountKey{
+		ClientEmail: "push@example.iam.gserviceaccount.com",
+		PrivateKey:  string(keyPEM),
+		TokenURI:    tokenServer.URL,
+	})
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	err = os.WriteFile(path, credentials, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := NewServiceAccountTokenSource(path, tokenServer.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		token, err := source.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "short-lived" {
+			t.Errorf("token = %q", token)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Errorf("token requested %d times, want 1", requests.Load())
+	}
+}
@@ -0,0 +1,129 @@
+package notifications_service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+	// tokenRefreshMargin токен обновляется заранее, чтобы не истек во время запроса
+	tokenRefreshMargin = time.Minute
+	assertionExp       = time.Hour
+)
+
+// TokenSource access токен для запросов к провайдеру
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// StaticTokenSource постоянный токен из настроек, пустой - без заголовка Authorization
+type StaticTokenSource string
+
+func (s StaticTokenSource) Token() (string, error) {
+	return string(s), nil
+}
+
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+/*
+ServiceAccountTokenSource короткоживущие токены OAuth2 для FCM HTTP v1
+по ключу сервисного аккаунта (JWT bearer grant, RFC 7523). Токен кешируется до истечения
+*/
+type ServiceAccountTokenSource struct {
+	key    serviceAccountKey
+	signer interface{}
+	client *http.Client
+
+	mx        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func NewServiceAccountTokenSource(credentialsFile string, client *http.Client) (*ServiceAccountTokenSource, error) {
+	raw, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var key serviceAccountKey
+	err = json.Unmarshal(raw, &key)
+	if err != nil {
+		return nil, err
+	}
+	if key.ClientEmail == "" || key.TokenURI == "" {
+		return nil, fmt.Errorf("push credentials %s: client_email and token_uri are required", credentialsFile)
+	}
+
+	signer, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(key.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("push credentials %s: %w", credentialsFile, err)
+	}
+
+	return &ServiceAccountTokenSource{
+		key:    key,
+		signer: signer,
+		client: client,
+	}, nil
+}
+
+func (s *ServiceAccountTokenSource) Token() (string, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.token != "" && time.Now().Add(tokenRefreshMargin).Before(s.expiresAt) {
+		return s.token, nil
+	}
+
+	now := time.Now()
+	assertion := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   s.key.ClientEmail,
+		"scope": fcmScope,
+		"aud":   s.key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(assertionExp).Unix(),
+	})
+	assertionString, err := assertion.SignedString(s.signer)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertionString)
+
+	resp, err := s.client.Post(s.key.TokenURI, "application/x-www-form-urlencoded", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", fmt.Errorf("push token response status=%d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", fmt.Errorf("push token response status=%d: %s", resp.StatusCode, result.Error)
+	}
+
+	s.token = result.AccessToken
+	s.expiresAt = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return s.token, nil
+}
@@ -0,0 +1,73 @@
+package oauth_service
+
+import (
+	"net/http"
+	"net/url"
+	"odo24_mobile_backend/config"
+	"strings"
+)
+
+const mailRuDefaultUserInfoURL = "https://oauth.mail.ru/userinfo"
+
+// mailRuMailDomains домены почты Mail.ru, внешние адреса аккаунта провайдер не подтверждает
+var mailRuMailDomains = []string{"mail.ru", "inbox.ru", "list.ru", "bk.ru", "internet.ru"}
+
+type MailRuProvider struct {
+	cfg    config.Oauth
+	client *http.Client
+}
+
+func NewMailRuProvider(cfg config.Oauth, client *http.Client) *MailRuProvider {
+	if cfg.UserInfoURL == "" {
+		cfg.UserInfoURL = mailRuDefaultUserInfoURL
+	}
+	return &MailRuProvider{
+		cfg:    cfg,
+		client: client,
+	}
+}
+
+func (p *MailRuProvider) Name() string {
+	return ProviderMailRu
+}
+
+func (p *MailRuProvider) ExchangeCode(code string) (string, error) {
+	return exchangeCode(p.client, p.cfg, code)
+}
+
+func (p *MailRuProvider) GetUserInfo(accessToken string) (*UserInfoModel, error) {
+	infoURL, err := url.Parse(p.cfg.UserInfoURL)
+	if err != nil {
+		return nil, err
+	}
+	query := infoURL.Query()
+	query.Set("access_token", accessToken)
+	infoURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, infoURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}
+	err = getJSON(p.client, req, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.ID == "" {
+		return nil, ErrIDNotProvided
+	}
+	if info.Email == "" {
+		return nil, ErrEmailNotProvided
+	}
+
+	return &UserInfoModel{
+		ID:            info.ID,
+		Email:         strings.ToLower(info.Email),
+		EmailVerified: isProviderMailbox(info.Email, mailRuMailDomains),
+	}, nil
+}
@@ -0,0 +1,9 @@
+package oauth_service
+
+// UserInfoModel профиль пользователя у OAuth провайдера
+type UserInfoModel struct {
+	ID    string
+	Email string
+	// EmailVerified владение адресом подтверждено провайдером (это почтовый ящик самого провайдера)
+	EmailVerified bool
+}
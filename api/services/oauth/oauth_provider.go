@@ -0,0 +1,148 @@
+package oauth_service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"odo24_mobile_backend/config"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderYandex = "yandex"
+	ProviderMailRu = "mailru"
+
+	defaultGrantType   = "authorization_code"
+	defaultHTTPTimeout = time.Second * 10
+)
+
+var (
+	ErrInvalidCode      = errors.New("oauth: invalid authorization code")
+	ErrEmailNotProvided = errors.New("oauth: provider did not return email")
+	ErrIDNotProvided    = errors.New("oauth: provider did not return user id")
+	ErrEmailNotVerified = errors.New("oauth: provider did not verify email")
+)
+
+// Provider внешний OAuth провайдер
+type Provider interface {
+	// Name имя провайдера, совпадает с параметром в URL
+	Name() string
+	// ExchangeCode обмен кода авторизации на access token провайдера
+	ExchangeCode(code string) (string, error)
+	// GetUserInfo профиль пользователя у провайдера
+	GetUserInfo(accessToken string) (*UserInfoModel, error)
+}
+
+// NewProviders провайдеры из настроек, ключ - имя провайдера
+func NewProviders(cfg config.OauthProviders) map[string]Provider {
+	client := &http.Client{
+		Timeout: defaultHTTPTimeout,
+	}
+
+	providers := make(map[string]Provider)
+	if cfg.Yandex.ClientID != "" {
+		providers[ProviderYandex] = NewYandexProvider(cfg.Yandex, client)
+	}
+	if cfg.MailRu.ClientID != "" {
+		providers[ProviderMailRu] = NewMailRuProvider(cfg.MailRu, client)
+	}
+	return providers
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchangeCode общий для провайдеров обмен кода по RFC 6749
+func exchangeCode(client *http.Client, cfg config.Oauth, code string) (string, error) {
+	grantType := cfg.GrantType
+	if grantType == "" {
+		grantType = defaultGrantType
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", grantType)
+	form.Set("code", code)
+	form.Set("client_id", cfg.ClientID)
+	form.Set("client_secret", cfg.ClientSecret)
+	if cfg.RedirectURI != "" {
+		form.Set("redirect_uri", cfg.RedirectURI)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(cfg.ClientID, cfg.ClientSecret)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var result tokenResponse
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return "", fmt.Errorf("oauth token response status=%d: %w", resp.StatusCode, err)
+	}
+
+	if result.Error != "" {
+		if result.Error == "invalid_grant" || result.Error == "bad_verification_code" {
+			return "", fmt.Errorf("%w: %s", ErrInvalidCode, result.ErrorDescription)
+		}
+		return "", fmt.Errorf("oauth token error: %s %s", result.Error, result.ErrorDescription)
+	}
+
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", fmt.Errorf("oauth token response status=%d", resp.StatusCode)
+	}
+
+	return result.AccessToken, nil
+}
+
+// isProviderMailbox адрес в одном из почтовых доменов провайдера, владение им провайдер проверил сам
+func isProviderMailbox(email string, domains []string) bool {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return false
+	}
+
+	domain := strings.ToLower(email[at+1:])
+	for _, d := range domains {
+		if domain == d {
+			return true
+		}
+	}
+	return false
+}
+
+// getJSON GET запрос к провайдеру с разбором ответа
+func getJSON(client *http.Client, req *http.Request, target interface{}) error {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oauth userinfo response status=%d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
@@ -0,0 +1,122 @@
+package oauth_service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"odo24_mobile_backend/config"
+	"testing"
+)
+
+// fakeProvider локальный OAuth провайдер: выдает токен на код "good" и профиль с заданными id и email
+type fakeProvider struct {
+	id    string
+	email string
+}
+
+func (p *fakeProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/token":
+		r.ParseForm()
+		if r.PostForm.Get("code") != "good" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "provider-token"})
+	case "/yandex":
+		if r.Header.Get("Authorization") != "OAuth provider-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": p.id, "default_email": p.email, "emails": []string{p.email}})
+	case "/mailru":
+		if r.URL.Query().Get("access_token") != "provider-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id": p.id, "email": p.email})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestProvidersEmailVerified(t *testing.T) {
+	fake := &fakeProvider{id: "42"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	newProviders := map[string]func(cfg config.Oauth) Provider{
+		ProviderYandex: func(cfg config.Oauth) Provider {
+			cfg.UserInfoURL = server.URL + "/yandex"
+			return NewYandexProvider(cfg, server.Client())
+		},
+		ProviderMailRu: func(cfg config.Oauth) Provider {
+			cfg.UserInfoURL = server.URL + "/mailru"
+			return NewMailRuProvider(cfg, server.Client())
+		},
+	}
+
+	cases := []struct {
+		provider string
+		email    string
+		verified bool
+	}{
+		{ProviderYandex, "User@Yandex.ru", true},
+		{ProviderYandex, "user@gmail.com", false},
+		{ProviderYandex, "user@yandex.ru.evil.com", false},
+		{ProviderMailRu, "user@mail.ru", true},
+		{ProviderMailRu, "user@bk.ru", true},
+		{ProviderMailRu, "victim@yandex.ru", false},
+	}
+	for _, tc := range cases {
+		fake.email = tc.email
+		provider := newProviders[tc.provider](config.Oauth{TokenURL: server.URL + "/token", ClientID: "id", ClientSecret: "secret"})
+
+		token, err := provider.ExchangeCode("good")
+		if err != nil {
+			t.Fatalf("%s %s: exchange: %v", tc.provider, tc.email, err)
+		}
+		info, err := provider.GetUserInfo(token)
+		if err != nil {
+			t.Fatalf("%s %s: userinfo: %v", tc.provider, tc.email, err)
+		}
+		if info.ID != "42" {
+			t.Errorf("%s %s: id = %q", tc.provider, tc.email, info.ID)
+		}
+		if info.EmailVerified != tc.verified {
+			t.Errorf("%s %s: verified = %v, want %v", tc.provider, tc.email, info.EmailVerified, tc.verified)
+		}
+	}
+}
+
+// TestProvidersRequireID ответ без id не должен привязываться к аккаунту с пустым external_id
+func TestProvidersRequireID(t *testing.T) {
+	server := httptest.NewServer(&fakeProvider{email: "user@yandex.ru"})
+	defer server.Close()
+
+	cfg := config.Oauth{TokenURL: server.URL + "/token"}
+	yandexCfg, mailRuCfg := cfg, cfg
+	yandexCfg.UserInfoURL = server.URL + "/yandex"
+	mailRuCfg.UserInfoURL = server.URL + "/mailru"
+
+	for _, provider := range []Provider{NewYandexProvider(yandexCfg, server.Client()), NewMailRuProvider(mailRuCfg, server.Client())} {
+		_, err := provider.GetUserInfo("provider-token")
+		if !errors.Is(err, ErrIDNotProvided) {
+			t.Errorf("%s: err = %v, want ErrIDNotProvided", provider.Name(), err)
+		}
+	}
+}
+
+func TestExchangeCodeInvalid(t *testing.T) {
+	server := httptest.NewServer(&fakeProvider{})
+	defer server.Close()
+
+	provider := NewYandexProvider(config.Oauth{TokenURL: server.URL + "/token"}, server.Client())
+	_, err := provider.ExchangeCode("bad")
+	if !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("err = %v, want ErrInvalidCode", err)
+	}
+}
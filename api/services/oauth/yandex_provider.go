@@ -0,0 +1,72 @@
+package oauth_service
+
+import (
+	"net/http"
+	"odo24_mobile_backend/config"
+	"strings"
+)
+
+const yandexDefaultUserInfoURL = "https://login.yandex.ru/info?format=json"
+
+// yandexMailDomains домены почты Яндекса, внешние адреса аккаунта провайдер не подтверждает
+var yandexMailDomains = []string{"yandex.ru", "ya.ru", "yandex.com", "yandex.by", "yandex.kz", "yandex.ua", "narod.ru"}
+
+type YandexProvider struct {
+	cfg    config.Oauth
+	client *http.Client
+}
+
+func NewYandexProvider(cfg config.Oauth, client *http.Client) *YandexProvider {
+	if cfg.UserInfoURL == "" {
+		cfg.UserInfoURL = yandexDefaultUserInfoURL
+	}
+	return &YandexProvider{
+		cfg:    cfg,
+		client: client,
+	}
+}
+
+func (p *YandexProvider) Name() string {
+	return ProviderYandex
+}
+
+func (p *YandexProvider) ExchangeCode(code string) (string, error) {
+	return exchangeCode(p.client, p.cfg, code)
+}
+
+func (p *YandexProvider) GetUserInfo(accessToken string) (*UserInfoModel, error) {
+	req, err := http.NewRequest(http.MethodGet, p.cfg.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "OAuth "+accessToken)
+
+	var info struct {
+		ID           string   `json:"id"`
+		DefaultEmail string   `json:"default_email"`
+		Emails       []string `json:"emails"`
+	}
+	err = getJSON(p.client, req, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	// по id аккаунт провайдера привязан к пользователю, пустой id совпал бы у всех таких ответов
+	if info.ID == "" {
+		return nil, ErrIDNotProvided
+	}
+
+	email := info.DefaultEmail
+	if email == "" && len(info.Emails) > 0 {
+		email = info.Emails[0]
+	}
+	if email == "" {
+		return nil, ErrEmailNotProvided
+	}
+
+	return &UserInfoModel{
+		ID:            info.ID,
+		Email:         strings.ToLower(email),
+		EmailVerified: isProviderMailbox(email, yandexMailDomains),
+	}, nil
+}
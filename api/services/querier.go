@@ -0,0 +1,10 @@
+package services
+
+import "database/sql"
+
+// Querier общий интерфейс *sql.DB и *sql.Tx, чтобы один запрос работал и вне транзакции, и внутри нее
+type Querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
@@ -0,0 +1,152 @@
+package register_service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	verification_service "odo24_mobile_backend/api/services/verification"
+	"odo24_mobile_backend/db"
+	"odo24_mobile_backend/sendmail"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const pgUniqueViolation = "23505"
+
+var (
+	ErrSameEmail       = errors.New("new email matches the current one")
+	ErrInvalidPassword = errors.New("invalid password")
+	ErrPasswordNotSet  = errors.New("password is not set")
+)
+
+/*
+ChangeEmailSendCode код подтверждения на новый адрес и уведомление на текущий.
+Нужен текущий пароль: одного токена доступа для смены логина мало. Без пароля (вход через OAuth)
+его сначала нужно задать через восстановление. Код привязан к пользователю и новому адресу
+*/
+func (srv *RegisterService) ChangeEmailSendCode(userID uint64, newEmail *mail.Address, password string, ip string) error {
+	guardKey := fmt.Sprintf("email_change.%d", userID)
+	err := srv.guard.Check(guardKey, ip)
+	if err != nil {
+		return err
+	}
+
+	pg := db.Conn()
+
+	var currentEmail string
+	var passwordHash, salt []byte
+	err = pg.QueryRow("select u.login,u.password_hash,u.salt from profiles.users u where u.user_id=$1", userID).Scan(&currentEmail, &passwordHash, &salt)
+	if err != nil {
+		return err
+	}
+	if len(passwordHash) == 0 {
+		return ErrPasswordNotSet
+	}
+
+	ok, _, err := srv.hasher.Verify(password, passwordHash, salt)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if _, failErr := srv.guard.Fail(guardKey, ip); failErr != nil {
+			log.Printf("fail attempt error: %v", failErr)
+		}
+		return ErrInvalidPassword
+	}
+
+	if strings.EqualFold(currentEmail, newEmail.Address) {
+		return ErrSameEmail
+	}
+
+	err = checkLoginIsFree(pg, newEmail.Address)
+	if err != nil {
+		return err
+	}
+
+	subject := changeEmailSubject(userID, newEmail)
+	code, _, err := srv.verification.Issue(verification_service.PurposeEmailChange, subject)
+	if err != nil {
+		return err
+	}
+
+	data := make(map[string]interface{})
+	data["code"] = code
+
+	err = sendmail.Enqueue(userID, newEmail.Address, sendmail.TypeChangeEmailCode, data)
+	if err != nil {
+		if discardErr := srv.verification.Discard(verification_service.PurposeEmailChange, subject); discardErr != nil {
+			log.Printf("discard code error: %v", discardErr)
+		}
+		return err
+	}
+
+	notifyData := make(map[string]interface{})
+	notifyData["new_email"] = newEmail.Address
+
+	err = sendmail.Enqueue(userID, currentEmail, sendmail.TypeChangeEmailNotify, notifyData)
+	if err != nil {
+		// код уже в очереди, смена не должна зависеть от доставки уведомления
+		log.Printf("change email notify error, user_id=%d: %v", userID, err)
+	}
+
+	return nil
+}
+
+// ChangeEmail смена логина по коду с нового адреса, остальные сессии пользователя завершаются
+func (srv *RegisterService) ChangeEmail(userID uint64, tokenUUID string, newEmail *mail.Address, code uint16, ip string) error {
+	guardKey := fmt.Sprintf("email_change.%d", userID)
+	err := srv.verifyCode(guardKey, verification_service.PurposeEmailChange, changeEmailSubject(userID, newEmail), code, ip)
+	if err != nil {
+		return err
+	}
+
+	pg := db.Conn()
+
+	tx, err := pg.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var currentEmail string
+	err = tx.QueryRow("select u.login from profiles.users u where u.user_id=$1 for update", userID).Scan(&currentEmail)
+	if err != nil {
+		return err
+	}
+
+	err = checkLoginIsFree(tx, newEmail.Address)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("update profiles.users set login=$1 where user_id=$2", newEmail.Address, userID)
+	if err != nil {
+		// адрес мог занять параллельный запрос после проверки
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgUniqueViolation {
+			return ErrLoginAlreadyExists
+		}
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("user_id=%d changed email %s -> %s", userID, currentEmail, newEmail.Address)
+
+	// логин уже изменен, ошибка завершения сессий не должна выглядеть как неудачная смена
+	err = srv.sessions.DeleteOthers(userID, tokenUUID)
+	if err != nil {
+		log.Printf("user_id=%d delete other sessions after email change error: %v", userID, err)
+	}
+
+	return nil
+}
+
+func changeEmailSubject(userID uint64, newEmail *mail.Address) string {
+	return fmt.Sprintf("%d.%s", userID, newEmail.Address)
+}
@@ -1,170 +1,174 @@
 package register_service
 
 import (
-	"encoding/binary"
+	"database/sql"
 	"errors"
 	"log"
-	"math/rand"
 	"net/mail"
 	"odo24_mobile_backend/api/services"
+	sessions_service "odo24_mobile_backend/api/services/sessions"
+	verification_service "odo24_mobile_backend/api/services/verification"
 	"odo24_mobile_backend/api/utils"
 	"odo24_mobile_backend/db"
 	"odo24_mobile_backend/sendmail"
-	"time"
+	"strconv"
+	"strings"
 )
 
 var (
 	ErrLoginAlreadyExists     = errors.New("errLoginAlreadyExists")
-	ErrCodeHasAlreadyBeenSent = errors.New("code has already been sent")
-	ErrCodeDoesNotMatch       = errors.New("code does not match")
+	ErrCodeHasAlreadyBeenSent = verification_service.ErrResendTooEarly
+	ErrCodeDoesNotMatch       = verification_service.ErrCodeDoesNotMatch
 )
 
 type RegisterService struct {
-	rnd *rand.Rand
+	hasher       *utils.PasswordHasher
+	sessions     *sessions_service.SessionsService
+	guard        *services.BruteForceGuard
+	verification *verification_service.VerificationService
 }
 
-func NewRegisterService() *RegisterService {
-	src := rand.NewSource(time.Now().UnixNano())
+func NewRegisterService(hasher *utils.PasswordHasher, sessions *sessions_service.SessionsService, guard *services.BruteForceGuard, verification *verification_service.VerificationService) *RegisterService {
 	return &RegisterService{
-		rnd: rand.New(src),
+		hasher:       hasher,
+		sessions:     sessions,
+		guard:        guard,
+		verification: verification,
 	}
 }
 
-func (srv *RegisterService) SendEmailCodeConfirmation(email *mail.Address) error {
-	existsCode, err := services.GetEmailCodeConfirmation(email)
-	if err != nil {
-		return err
-	}
-	if existsCode != nil {
-		log.Printf("SendEmailCodeConfirmation code is exists")
-		return nil
-	}
-
-	code := srv.generateConfirmationCode()
-
-	err = services.AddEmailCodeConfirmation(email, code)
-	if err != nil {
-		return err
-	}
-
-	data := make(map[string]interface{})
-	data["code"] = code
-
-	err = sendmail.SendEmail(email.Address, sendmail.TypeConfirmEmail, data)
-	return err
+func (srv *RegisterService) SendEmailCodeConfirmation(email *mail.Address) (*verification_service.SendCodeResultModel, error) {
+	return srv.sendCode(email, verification_service.PurposeRegister, sendmail.TypeConfirmEmail)
 }
 
-func (srv *RegisterService) PasswordRecoverySendEmailCodeConfirmation(email *mail.Address) error {
-	existsCode, err := services.GetEmailCodeConfirmation(email)
-	if err != nil {
-		return err
-	}
-	if existsCode != nil {
-		return ErrCodeHasAlreadyBeenSent
-	}
+func (srv *RegisterService) PasswordRecoverySendEmailCodeConfirmation(email *mail.Address) (*verification_service.SendCodeResultModel, error) {
+	return srv.sendCode(email, verification_service.PurposeRecover, sendmail.TypeRepairConfirmCode)
+}
 
-	code := srv.generateConfirmationCode()
+// CodeStatus состояние отправленного кода регистрации или восстановления, statusToken - из ответа на отправку кода
+func (srv *RegisterService) CodeStatus(email *mail.Address, purpose verification_service.Purpose, statusToken string) (*verification_service.CodeStatusModel, error) {
+	return srv.verification.Status(purpose, email.Address, statusToken)
+}
 
-	err = services.AddEmailCodeConfirmation(email, code)
+func (srv *RegisterService) sendCode(email *mail.Address, purpose verification_service.Purpose, tplID uint8) (*verification_service.SendCodeResultModel, error) {
+	code, statusToken, err := srv.verification.Issue(purpose, email.Address)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	data := make(map[string]interface{})
 	data["code"] = code
 
-	err = sendmail.SendEmail(email.Address, sendmail.TypeRepairConfirmCode, data)
-	return err
-}
-
-func (srv *RegisterService) RegisterByEmail(email *mail.Address, code uint16, password string) error {
-	item, err := services.GetEmailCodeConfirmation(email)
+	err = sendmail.Enqueue(0, email.Address, tplID, data)
 	if err != nil {
-		return err
-	}
-
-	if item == nil {
-		log.Println("RegisterByEmail GetEmailCodeConfirmation code is empty")
-		return ErrCodeDoesNotMatch
+		// письмо не попало в очередь, код не должен блокировать повторную отправку
+		if discardErr := srv.verification.Discard(purpose, email.Address); discardErr != nil {
+			log.Printf("discard code error: %v", discardErr)
+		}
+		return nil, err
 	}
 
-	confirmCode := binary.LittleEndian.Uint16(item.Value)
-
-	if code != confirmCode {
-		return ErrCodeDoesNotMatch
-	}
+	return &verification_service.SendCodeResultModel{StatusToken: statusToken}, nil
+}
 
-	salt, err := utils.GenerateSalt()
+func (srv *RegisterService) RegisterByEmail(email *mail.Address, code uint16, password string, ip string) error {
+	err := srv.checkConfirmationCode(email, verification_service.PurposeRegister, code, ip)
 	if err != nil {
 		return err
 	}
 
-	newPassword, err := utils.GetPasswordHash([]byte(password), salt)
+	newPassword, err := srv.hasher.Hash(password)
 	if err != nil {
 		return err
 	}
 
 	pg := db.Conn()
 
-	var emailIsExists bool
-	err = pg.QueryRow("SELECT EXISTS(SELECT 1 from profiles.users u WHERE u.login=$1)", email.Address).Scan(&emailIsExists)
+	err = checkLoginIsFree(pg, email.Address)
 	if err != nil {
 		return err
 	}
-	if emailIsExists {
-		return ErrLoginAlreadyExists
-	}
 
 	var userID uint64
-	err = pg.QueryRow(`INSERT INTO profiles.users (login,password_hash,oauth,last_login_dt,salt) VALUES($1,$2,$3,now()::timestamp without time zone,$4) RETURNING user_id`, email.Address, newPassword, false, salt).Scan(&userID)
+	err = pg.QueryRow(`INSERT INTO profiles.users (login,password_hash,oauth,last_login_dt,salt) VALUES($1,$2,$3,now()::timestamp without time zone,$4) RETURNING user_id`, email.Address, newPassword, false, []byte{}).Scan(&userID)
 	if err != nil {
 		return err
 	}
 
-	services.DeleteEmailCodeConfirmation(email)
 	return nil
 }
 
-func (srv *RegisterService) PasswordRecovery(email *mail.Address, code uint16, password string) error {
-	item, err := services.GetEmailCodeConfirmation(email)
+func (srv *RegisterService) PasswordRecovery(email *mail.Address, code uint16, password string, ip string) error {
+	err := srv.checkConfirmationCode(email, verification_service.PurposeRecover, code, ip)
 	if err != nil {
 		return err
 	}
 
-	if item == nil {
-		log.Println("PasswordRecovery GetEmailCodeConfirmation code is empty")
-		return ErrCodeDoesNotMatch
+	newPassword, err := srv.hasher.Hash(password)
+	if err != nil {
+		return err
 	}
 
-	confirmCode := binary.LittleEndian.Uint16(item.Value)
+	pg := db.Conn()
+	var userID uint64
+	err = pg.QueryRow("UPDATE profiles.users SET password_hash=$1,salt=$2 WHERE login=$3 RETURNING user_id;", newPassword, []byte{}, email.Address).Scan(&userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
 
-	if code != confirmCode {
-		return ErrCodeDoesNotMatch
+	if userID == 0 {
+		return nil
 	}
 
-	salt, err := utils.GenerateSalt()
+	// после восстановления пароля все устройства должны авторизоваться заново
+	return srv.sessions.DeleteAll(userID)
+}
+
+/*
+checkConfirmationCode проверка кода из письма.
+Код аннулируется после нескольких неверных вводов, дополнительно перебор ограничен по email и IP
+*/
+func (srv *RegisterService) checkConfirmationCode(email *mail.Address, purpose verification_service.Purpose, code uint16, ip string) error {
+	guardKey := "code." + strings.ToLower(email.Address)
+	return srv.verifyCode(guardKey, purpose, email.Address, code, ip)
+}
+
+func (srv *RegisterService) verifyCode(guardKey string, purpose verification_service.Purpose, subject string, code uint16, ip string) error {
+	err := srv.guard.Check(guardKey, ip)
 	if err != nil {
 		return err
 	}
 
-	newPassword, err := utils.GetPasswordHash([]byte(password), salt)
+	err = srv.verification.Verify(purpose, subject, strconv.FormatUint(uint64(code), 10))
 	if err != nil {
+		if errors.Is(err, ErrCodeDoesNotMatch) {
+			if _, failErr := srv.guard.Fail(guardKey, ip); failErr != nil {
+				log.Printf("fail attempt error: %v", failErr)
+			}
+		}
 		return err
 	}
 
-	pg := db.Conn()
-	_, err = pg.Exec("UPDATE profiles.users SET password_hash=$1,salt=$2 WHERE login=$3;", newPassword, salt, email.Address)
+	err = srv.guard.Reset(guardKey)
 	if err != nil {
-		return err
+		log.Printf("reset attempts error: %v", err)
 	}
-
-	services.DeleteEmailCodeConfirmation(email)
 	return nil
 }
 
-func (ctrl *RegisterService) generateConfirmationCode() uint16 {
-	var value = ctrl.rnd.Uint32()
-	value %= (9999 - 1000)
-	value += 1000
-	return uint16(value)
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// checkLoginIsFree ErrLoginAlreadyExists, если email уже занят другим пользователем
+func checkLoginIsFree(q queryRower, email string) error {
+	var emailIsExists bool
+	err := q.QueryRow("SELECT EXISTS(SELECT 1 from profiles.users u WHERE u.login=$1)", email).Scan(&emailIsExists)
+	if err != nil {
+		return err
+	}
+	if emailIsExists {
+		return ErrLoginAlreadyExists
+	}
+	return nil
 }
@@ -0,0 +1,98 @@
+package register_service
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"odo24_mobile_backend/api/services"
+	verification_service "odo24_mobile_backend/api/services/verification"
+	"odo24_mobile_backend/db"
+	"odo24_mobile_backend/sendmail"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var codePattern = regexp.MustCompile(`<strong>(\d+)</strong>`)
+
+// testDBEnv строка подключения к тестовой базе с примененными миграциями
+const testDBEnv = "ODO24_TEST_DB"
+
+/*
+TestSendCodeThroughOutbox код регистрации проходит рабочий путь: очередь в Postgres и воркер Outbox,
+транспорт воркера - MemoryMailer
+*/
+func TestSendCodeThroughOutbox(t *testing.T) {
+	connection := os.Getenv(testDBEnv)
+	if connection == "" {
+		t.Skipf("%s не задан", testDBEnv)
+	}
+	err := db.CreateConnection(db.Options{DriverName: "postgres", ConnectionString: connection})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sendmail.LoadTemplates("../../../sendmail")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := sendmail.NewMemoryMailer()
+	outbox := sendmail.NewOutbox(func() sendmail.Mailer { return mailer }, sendmail.OutboxOptions{})
+
+	guard := services.NewBruteForceGuard(services.NewMemoryAttemptStore())
+	verification := verification_service.NewVerificationService(services.NewMemoryCodeStore())
+	srv := NewRegisterService(nil, nil, guard, verification)
+
+	email := &mail.Address{Address: fmt.Sprintf("outbox.%d@example.com", time.Now().UnixNano())}
+	defer db.Conn().Exec("DELETE FROM profiles.email_outbox WHERE recipient=$1", email.Address)
+
+	result, err := srv.SendEmailCodeConfirmation(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := srv.CodeStatus(email, verification_service.PurposeRegister, "wrong-token")
+	if err != nil || status.Sent {
+		t.Fatalf("status with a foreign token must look like no code was sent: %+v, %v", status, err)
+	}
+	status, err = srv.CodeStatus(email, verification_service.PurposeRegister, result.StatusToken)
+	if err != nil || !status.Sent {
+		t.Fatalf("status with the issued token: %+v, %v", status, err)
+	}
+
+	// в очереди могут быть и другие письма, отправляем до нужного
+	message, ok := mailer.Last(email.Address)
+	for !ok {
+		found, err := outbox.SendNext(mailer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found {
+			t.Fatal("confirmation email was not delivered from the outbox")
+		}
+		message, ok = mailer.Last(email.Address)
+	}
+
+	match := codePattern.FindStringSubmatch(message.Body)
+	if match == nil {
+		t.Fatalf("code not found in body:\n%s", message.Body)
+	}
+	code, err := strconv.ParseUint(match[1], 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// другой код из того же диапазона 1000-9999
+	wrongCode := uint16(code%9000 + 1000)
+	err = srv.checkConfirmationCode(email, verification_service.PurposeRegister, wrongCode, "127.0.0.1")
+	if !errors.Is(err, ErrCodeDoesNotMatch) {
+		t.Fatalf("wrong code: err = %v, want ErrCodeDoesNotMatch", err)
+	}
+
+	err = srv.checkConfirmationCode(email, verification_service.PurposeRegister, uint16(code), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("captured code rejected: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package reminders_service
+
+/*
+ReminderOptions напоминание отправляется, когда до обслуживания осталось не больше DistanceKm или Days.
+Просроченные больше чем на MaxOverdueKm или MaxOverdueDays не напоминаются, например давние записи до включения напоминаний
+*/
+type ReminderOptions struct {
+	DistanceKm     uint32
+	Days           int
+	MaxOverdueKm   uint32
+	MaxOverdueDays int
+}
+
+type userRecipient struct {
+	UserID uint64
+	Email  string
+}
+
+type carRow struct {
+	CarID  uint64
+	UserID uint64
+	Name   string
+	Odo    uint32
+}
+
+// dueItem группа авто, по которой пора напомнить об обслуживании
+type dueItem struct {
+	ServiceID uint64
+	CarName   string
+	GroupID   uint64
+	DueBy     string
+	// KmLeft остаток пробега, отрицательный - пробег превышен
+	KmLeft int64
+	// DaysLeft дней до срока, отрицательное значение - срок прошел
+	DaysLeft int
+}
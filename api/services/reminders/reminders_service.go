@@ -0,0 +1,465 @@
+package reminders_service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	cars_service "odo24_mobile_backend/api/services/cars"
+	groups_service "odo24_mobile_backend/api/services/groups"
+	notifications_service "odo24_mobile_backend/api/services/notifications"
+	"odo24_mobile_backend/db"
+	"odo24_mobile_backend/sendmail"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultDistanceKm     = 500
+	defaultDays           = 14
+	defaultMaxOverdueKm   = 3000
+	defaultMaxOverdueDays = 60
+	usersBatchSize        = 200
+	// schedulerLockKey ключ advisory lock, рассылку выполняет только один экземпляр сервиса
+	schedulerLockKey int64 = 0x6f646f3234726d
+)
+
+type RemindersService struct {
+	cars          *cars_service.CarsService
+	groups        *groups_service.GroupsService
+	notifications *notifications_service.NotificationsService
+	options       ReminderOptions
+}
+
+func NewRemindersService(cars *cars_service.CarsService, groups *groups_service.GroupsService, notifications *notifications_service.NotificationsService, options ReminderOptions) *RemindersService {
+	if options.DistanceKm == 0 {
+		options.DistanceKm = defaultDistanceKm
+	}
+	if options.Days <= 0 {
+		options.Days = defaultDays
+	}
+	if options.MaxOverdueKm == 0 {
+		options.MaxOverdueKm = defaultMaxOverdueKm
+	}
+	if options.MaxOverdueDays <= 0 {
+		options.MaxOverdueDays = defaultMaxOverdueDays
+	}
+
+	return &RemindersService{
+		cars:          cars,
+		groups:        groups,
+		notifications: notifications,
+		options:       options,
+	}
+}
+
+// RunScheduler периодический запуск SendDue, блокирует вызывающую горутину
+func (srv *RemindersService) RunScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := srv.SendDue()
+		if err != nil {
+			log.Printf("send reminders error: %v", err)
+		}
+	}
+}
+
+/*
+SendDue напоминания всем пользователям, у которых подходит срок обслуживания.
+Одному пользователю - одно письмо и один push по всем авто. Ошибка отправки не прерывает обход,
+неотправленные напоминания повторяются при следующем запуске.
+Пока рассылку выполняет другой экземпляр (advisory lock занят), запуск пропускается
+*/
+func (srv *RemindersService) SendDue() error {
+	ctx := context.Background()
+	conn, err := db.Conn().Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", schedulerLockKey).Scan(&locked)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+	defer func() {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", schedulerLockKey)
+		if err != nil {
+			log.Printf("reminders unlock error: %v", err)
+		}
+	}()
+
+	var lastUserID uint64
+	for {
+		users, err := srv.getUsers(lastUserID)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			return nil
+		}
+		lastUserID = users[len(users)-1].UserID
+
+		due, err := srv.findDue(users)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			items, ok := due[user.UserID]
+			if !ok {
+				continue
+			}
+
+			err = srv.send(user, items)
+			if err != nil {
+				log.Printf("send reminder to user_id=%d error: %v", user.UserID, err)
+			}
+		}
+	}
+}
+
+// getUsers пользователи с авто, кроме запросивших удаление аккаунта
+func (srv *RemindersService) getUsers(afterUserID uint64) ([]userRecipient, error) {
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT u.user_id,u.login FROM profiles.users u
+		WHERE u.user_id>$1 AND u.delete_after IS NULL
+		AND EXISTS(SELECT 1 FROM service_book.car c WHERE c.user_id=u.user_id)
+		ORDER BY u.user_id
+		LIMIT $2`, afterUserID, usersBatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var users []userRecipient
+	for rows.Next() {
+		var user userRecipient
+		err := rows.Scan(&user.UserID, &user.Email)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// findDue последние записи групп (как в списке авто), по которым подошел срок и напоминание еще не отправлялось
+func (srv *RemindersService) findDue(users []userRecipient) (map[uint64][]dueItem, error) {
+	userIDs := make([]uint64, 0, len(users))
+	for _, user := range users {
+		userIDs = append(userIDs, user.UserID)
+	}
+
+	cars, err := srv.getCars(userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	carIDs := make([]uint64, 0, len(cars))
+	for _, car := range cars {
+		carIDs = append(carIDs, car.CarID)
+	}
+
+	info, err := srv.cars.GetCarNextServiceInformation(carIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	type candidate struct {
+		UserID uint64
+		Item   dueItem
+	}
+	var candidates []candidate
+	var serviceIDs []uint64
+	for _, car := range cars {
+		for groupID, data := range info[car.CarID] {
+			item := dueItem{
+				ServiceID: data.ServiceID,
+				CarName:   car.Name,
+				GroupID:   groupID,
+			}
+
+			if data.Odo != nil && data.NextOdo != nil {
+				item.KmLeft = int64(*data.Odo) + int64(*data.NextOdo) - int64(car.Odo)
+				if item.KmLeft <= int64(srv.options.DistanceKm) {
+					item.DueBy = cars_service.DueByDistance
+				}
+			}
+
+			if data.NextDate != nil {
+				nextDate, err := time.Parse(time.DateOnly, *data.NextDate)
+				if err == nil {
+					item.DaysLeft = int(nextDate.Sub(today).Hours() / 24)
+					if item.DaysLeft <= srv.options.Days && item.DueBy == "" {
+						item.DueBy = cars_service.DueByTime
+					}
+				}
+			}
+
+			if item.DueBy == "" || srv.overdueTooLong(item) {
+				continue
+			}
+			candidates = append(candidates, candidate{UserID: car.UserID, Item: item})
+			serviceIDs = append(serviceIDs, item.ServiceID)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sent, err := srv.getSent(serviceIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[uint64][]dueItem)
+	for _, c := range candidates {
+		if _, ok := sent[c.Item.ServiceID]; ok {
+			continue
+		}
+		result[c.UserID] = append(result[c.UserID], c.Item)
+	}
+
+	return result, nil
+}
+
+// overdueTooLong срок давно прошел, напоминать поздно
+func (srv *RemindersService) overdueTooLong(item dueItem) bool {
+	if item.DueBy == cars_service.DueByDistance {
+		return item.KmLeft < -int64(srv.options.MaxOverdueKm)
+	}
+	return item.DaysLeft < -srv.options.MaxOverdueDays
+}
+
+func (srv *RemindersService) getCars(userIDs []uint64) ([]carRow, error) {
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT c.car_id,c.user_id,c."name",c.odo FROM service_book.car c
+		WHERE c.user_id = ANY($1)
+		ORDER BY c.car_id`, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var cars []carRow
+	for rows.Next() {
+		var car carRow
+		err := rows.Scan(&car.CarID, &car.UserID, &car.Name, &car.Odo)
+		if err != nil {
+			return nil, err
+		}
+		cars = append(cars, car)
+	}
+
+	return cars, rows.Err()
+}
+
+func (srv *RemindersService) getSent(serviceIDs []uint64) (map[uint64]struct{}, error) {
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT r.service_id FROM service_book.reminders_sent r WHERE r.service_id = ANY($1)`, pq.Array(serviceIDs))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sent := make(map[uint64]struct{})
+	for rows.Next() {
+		var serviceID uint64
+		err := rows.Scan(&serviceID)
+		if err != nil {
+			return nil, err
+		}
+		sent[serviceID] = struct{}{}
+	}
+
+	return sent, rows.Err()
+}
+
+/*
+send письмо и push пользователю по его настройкам уведомлений.
+Напоминания отмечаются отправленными до отправки, если не сработал ни один канал - отметка снимается
+*/
+func (srv *RemindersService) send(user userRecipient, items []dueItem) (err error) {
+	prefs, err := srv.notifications.GetPreferences(user.UserID)
+	if err != nil {
+		return err
+	}
+	if !prefs.ReminderEmail && !(prefs.PushEnabled && prefs.ReminderPush) {
+		return nil
+	}
+
+	items, err = claim(items)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	defer func() {
+		if err != nil {
+			if releaseErr := release(items); releaseErr != nil {
+				log.Printf("release reminders of user_id=%d error: %v", user.UserID, releaseErr)
+			}
+		}
+	}()
+
+	groupIDs := make([]uint64, 0, len(items))
+	for _, item := range items {
+		groupIDs = append(groupIDs, item.GroupID)
+	}
+
+	groups, err := srv.groups.GetGroupsByIDs(groupIDs)
+	if err != nil {
+		return err
+	}
+	groupNames := make(map[uint64]string, len(groups))
+	for _, group := range groups {
+		groupNames[group.GroupID] = group.Name
+	}
+
+	lines := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		groupName, ok := groupNames[item.GroupID]
+		if !ok {
+			groupName = "Group " + strconv.FormatUint(item.GroupID, 10)
+		}
+
+		lines = append(lines, map[string]interface{}{
+			"car":   item.CarName,
+			"group": groupName,
+			"due":   describeDue(item),
+		})
+	}
+
+	var delivered bool
+	if prefs.ReminderEmail {
+		data := make(map[string]interface{})
+		data["items"] = lines
+
+		err = sendmail.Enqueue(user.UserID, user.Email, sendmail.TypeServiceReminder, data)
+		if err != nil {
+			log.Printf("enqueue reminder email to user_id=%d error: %v", user.UserID, err)
+		} else {
+			delivered = true
+		}
+	}
+
+	pushed, err := srv.notifications.NotifyUser(user.UserID, reminderPush(lines))
+	if err != nil {
+		log.Printf("send reminder push to user_id=%d error: %v", user.UserID, err)
+	}
+	if pushed > 0 {
+		delivered = true
+	}
+
+	if !delivered {
+		return errors.New("reminder was not delivered")
+	}
+
+	return nil
+}
+
+// claim отметка напоминаний отправленными, возвращаются только те, что не отметил кто-то раньше
+func claim(items []dueItem) ([]dueItem, error) {
+	serviceIDs := make([]uint64, 0, len(items))
+	dueBy := make([]string, 0, len(items))
+	for _, item := range items {
+		serviceIDs = append(serviceIDs, item.ServiceID)
+		dueBy = append(dueBy, item.DueBy)
+	}
+
+	pg := db.Conn()
+	rows, err := pg.Query(`INSERT INTO service_book.reminders_sent (service_id,due_by)
+		SELECT * FROM unnest($1::bigint[], $2::varchar[])
+		ON CONFLICT (service_id) DO NOTHING
+		RETURNING service_id`, pq.Array(serviceIDs), pq.Array(dueBy))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	claimed := make(map[uint64]struct{})
+	for rows.Next() {
+		var serviceID uint64
+		err := rows.Scan(&serviceID)
+		if err != nil {
+			return nil, err
+		}
+		claimed[serviceID] = struct{}{}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []dueItem
+	for _, item := range items {
+		if _, ok := claimed[item.ServiceID]; ok {
+			result = append(result, item)
+		}
+	}
+	return result, nil
+}
+
+// release снятие отметки неотправленных напоминаний, они повторятся при следующем запуске
+func release(items []dueItem) error {
+	serviceIDs := make([]uint64, 0, len(items))
+	for _, item := range items {
+		serviceIDs = append(serviceIDs, item.ServiceID)
+	}
+
+	pg := db.Conn()
+	_, err := pg.Exec(`DELETE FROM service_book.reminders_sent WHERE service_id = ANY($1)`, pq.Array(serviceIDs))
+	return err
+}
+
+// reminderPush одна строка на первую группу, остальные - количеством
+func reminderPush(lines []map[string]interface{}) notifications_service.PushMessage {
+	body := fmt.Sprintf("%s, %s: %s", lines[0]["car"], lines[0]["group"], lines[0]["due"])
+	if len(lines) > 1 {
+		body += fmt.Sprintf(" и еще %d", len(lines)-1)
+	}
+
+	return notifications_service.PushMessage{
+		Kind:  notifications_service.KindReminder,
+		Title: "Подходит срок обслуживания",
+		Body:  body,
+	}
+}
+
+func describeDue(item dueItem) string {
+	if item.DueBy == cars_service.DueByDistance {
+		if item.KmLeft <= 0 {
+			return fmt.Sprintf("пробег превышен на %d км", -item.KmLeft)
+		}
+		return fmt.Sprintf("осталось %d км", item.KmLeft)
+	}
+
+	if item.DaysLeft < 0 {
+		return fmt.Sprintf("срок прошел %d дн. назад", -item.DaysLeft)
+	}
+	if item.DaysLeft == 0 {
+		return "срок сегодня"
+	}
+	return fmt.Sprintf("осталось %d дн.", item.DaysLeft)
+}
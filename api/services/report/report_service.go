@@ -0,0 +1,336 @@
+package report_service
+
+import (
+	"fmt"
+	"io"
+	"log"
+	car_services_service "odo24_mobile_backend/api/services/car_services"
+	cars_service "odo24_mobile_backend/api/services/cars"
+	groups_service "odo24_mobile_backend/api/services/groups"
+	"odo24_mobile_backend/pdf"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pageMargin   = 40.0
+	footerOffset = 24.0
+	contentWidth = pdf.A4Width - pageMargin*2
+
+	titleSize  = 18.0
+	headerSize = 11.0
+	groupSize  = 13.0
+	tableSize  = 9.5
+	lineHeight = 1.35
+	cellIndent = 4.0
+)
+
+type column struct {
+	title string
+	width float64
+	right bool
+}
+
+var tableColumns = []column{
+	{title: "Дата", width: 70},
+	{title: "Пробег, км", width: 75, right: true},
+	{title: "Стоимость, руб.", width: 90, right: true},
+	{title: "Описание", width: contentWidth - 235},
+}
+
+type ReportService struct {
+	cars        *cars_service.CarsService
+	groups      *groups_service.GroupsService
+	carServices *car_services_service.CarServicesService
+	font        *pdf.TrueTypeFont
+}
+
+/*
+NewReportService fontPath - TrueType шрифт с кириллицей, встраивается в документ.
+Заданный, но нечитаемый шрифт - ошибка настройки. Без шрифта используется Helvetica,
+кириллица в отчете транслитерируется
+*/
+func NewReportService(cars *cars_service.CarsService, groups *groups_service.GroupsService, carServices *car_services_service.CarServicesService, fontPath string) (*ReportService, error) {
+	srv := &ReportService{
+		cars:        cars,
+		groups:      groups,
+		carServices: carServices,
+	}
+
+	if fontPath == "" {
+		log.Println("WARNING: report.font_path is not set, PDF reports use Helvetica and transliterate Cyrillic")
+		return srv, nil
+	}
+
+	font, err := pdf.LoadTrueTypeFont(fontPath)
+	if err != nil {
+		return nil, fmt.Errorf("load report font %s: %w", fontPath, err)
+	}
+	srv.font = font
+
+	return srv, nil
+}
+
+type groupRecords struct {
+	group   groups_service.GroupModel
+	records []car_services_service.CarServiceModel
+}
+
+// CarServiceBook сервисная книжка авто в PDF: все группы с записями и итоги
+func (srv *ReportService) CarServiceBook(userID, carID uint64, w io.Writer) error {
+	car, err := srv.cars.GetCar(carID)
+	if err != nil {
+		return err
+	}
+
+	groups, err := srv.groups.GetGroupsByUser(userID)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].Sort < groups[j].Sort
+	})
+
+	var book []groupRecords
+	for _, group := range groups {
+		records, err := srv.carServices.GetServices(carID, group.GroupID)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			continue
+		}
+		sort.SliceStable(records, func(i, j int) bool {
+			if records[i].Dt != records[j].Dt {
+				return records[i].Dt < records[j].Dt
+			}
+			return value(records[i].Odo) < value(records[j].Odo)
+		})
+		book = append(book, groupRecords{group: group, records: records})
+	}
+
+	doc := pdf.NewDocument(srv.font)
+	doc.SetTitle("Сервисная книжка " + car.Name)
+
+	layout := newReportLayout(doc)
+	layout.header(car)
+
+	var totalCount int
+	var totalPrice uint64
+	for _, item := range book {
+		count, price := layout.group(item)
+		totalCount += count
+		totalPrice += price
+	}
+
+	layout.totals(len(book), totalCount, totalPrice)
+	layout.footers()
+
+	_, err = doc.WriteTo(w)
+	return err
+}
+
+// reportLayout верстка сверху вниз с переносом на новую страницу
+type reportLayout struct {
+	doc    *pdf.Document
+	y      float64
+	bottom float64
+}
+
+func newReportLayout(doc *pdf.Document) *reportLayout {
+	_, height := doc.Size()
+	layout := &reportLayout{
+		doc:    doc,
+		bottom: height - pageMargin - footerOffset,
+	}
+	layout.newPage()
+	return layout
+}
+
+func (l *reportLayout) newPage() {
+	l.doc.AddPage()
+	l.y = pageMargin
+}
+
+// ensureSpace новая страница, если блок высотой height не помещается
+func (l *reportLayout) ensureSpace(height float64) bool {
+	if l.y+height <= l.bottom {
+		return false
+	}
+	l.newPage()
+	return true
+}
+
+func (l *reportLayout) text(x, size float64, text string) {
+	l.doc.Text(x, l.y+size, size, text)
+}
+
+func (l *reportLayout) header(car *cars_service.CarModel) {
+	for _, line := range l.doc.WrapText(car.Name, titleSize, contentWidth) {
+		l.text(pageMargin, titleSize, line)
+		l.y += titleSize * lineHeight
+	}
+	l.y += 4
+
+	lines := []string{
+		"Сервисная книжка автомобиля",
+		fmt.Sprintf("Текущий пробег: %s км", formatNumber(uint64(car.Odo))),
+		fmt.Sprintf("Дата формирования: %s", time.Now().Format("02.01.2006")),
+	}
+	for _, line := range lines {
+		l.text(pageMargin, headerSize, line)
+		l.y += headerSize * lineHeight
+	}
+
+	l.y += 6
+	l.doc.Line(pageMargin, l.y, pageMargin+contentWidth, l.y, 1)
+	l.y += 14
+}
+
+// group таблица записей группы, возвращает число записей и сумму стоимости
+func (l *reportLayout) group(item groupRecords) (int, uint64) {
+	rowHeight := tableSize * lineHeight
+	// заголовок группы не должен оставаться внизу страницы без записей
+	l.ensureSpace(groupSize*lineHeight + rowHeight*3)
+
+	l.text(pageMargin, groupSize, item.group.Name)
+	l.y += groupSize*lineHeight + 4
+	l.tableHeader()
+
+	var total uint64
+	for _, record := range item.records {
+		descriptionLines := []string{""}
+		if record.Description != nil {
+			descriptionLines = l.doc.WrapText(*record.Description, tableSize, tableColumns[3].width-cellIndent*2)
+		}
+
+		if l.ensureSpace(rowHeight*float64(len(descriptionLines)) + 4) {
+			l.tableHeader()
+		}
+
+		cells := []string{
+			formatDate(record.Dt),
+			formatOptional(record.Odo),
+			formatOptional(record.Price),
+		}
+		for i, cell := range cells {
+			l.cell(i, cell)
+		}
+		for i, line := range descriptionLines {
+			l.doc.Text(l.columnX(3)+cellIndent, l.y+tableSize*(1+float64(i)*lineHeight), tableSize, line)
+		}
+
+		l.y += rowHeight*float64(len(descriptionLines)) + 4
+		l.doc.Line(pageMargin, l.y-2, pageMargin+contentWidth, l.y-2, 0.25)
+
+		total += uint64(value(record.Price))
+	}
+
+	l.ensureSpace(rowHeight + 4)
+	l.y += 2
+	l.text(pageMargin+cellIndent, tableSize, fmt.Sprintf("Записей: %d", len(item.records)))
+	l.cell(2, formatNumber(total))
+	l.y += rowHeight + 16
+
+	return len(item.records), total
+}
+
+func (l *reportLayout) tableHeader() {
+	height := tableSize*lineHeight + 6
+	l.doc.FillRect(pageMargin, l.y, contentWidth, height, 0.9)
+	l.y += 3
+	for i := range tableColumns {
+		l.cell(i, tableColumns[i].title)
+	}
+	l.y += height - 3 + 2
+}
+
+func (l *reportLayout) cell(index int, text string) {
+	column := tableColumns[index]
+	x := l.columnX(index) + cellIndent
+	if column.right {
+		x = l.columnX(index) + column.width - cellIndent - l.doc.TextWidth(text, tableSize)
+	}
+	l.text(x, tableSize, text)
+}
+
+func (l *reportLayout) columnX(index int) float64 {
+	x := pageMargin
+	for i := 0; i < index; i++ {
+		x += tableColumns[i].width
+	}
+	return x
+}
+
+func (l *reportLayout) totals(groupsCount, count int, price uint64) {
+	l.ensureSpace(headerSize * lineHeight * 4)
+	l.doc.Line(pageMargin, l.y, pageMargin+contentWidth, l.y, 1)
+	l.y += 10
+
+	if count == 0 {
+		l.text(pageMargin, headerSize, "Записей об обслуживании нет")
+		l.y += headerSize * lineHeight
+		return
+	}
+
+	lines := []string{
+		fmt.Sprintf("Групп обслуживания: %d", groupsCount),
+		fmt.Sprintf("Всего записей: %d", count),
+		fmt.Sprintf("Общая стоимость: %s руб.", formatNumber(price)),
+	}
+	for _, line := range lines {
+		l.text(pageMargin, headerSize, line)
+		l.y += headerSize * lineHeight
+	}
+}
+
+// footers номера страниц, проставляются после верстки, когда известно их число
+func (l *reportLayout) footers() {
+	_, height := l.doc.Size()
+	pages := l.doc.PageCount()
+	for i := 0; i < pages; i++ {
+		l.doc.SetPage(i)
+		text := fmt.Sprintf("odo24.ru · Страница %d из %d", i+1, pages)
+		x := pageMargin + contentWidth - l.doc.TextWidth(text, tableSize)
+		l.doc.Text(x, height-pageMargin, tableSize, text)
+	}
+}
+
+func formatDate(dt string) string {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		t, err := time.Parse(layout, dt)
+		if err == nil {
+			return t.Format("02.01.2006")
+		}
+	}
+	return dt
+}
+
+func formatOptional(v *uint32) string {
+	if v == nil {
+		return "—"
+	}
+	return formatNumber(uint64(*v))
+}
+
+// formatNumber число с пробелами между разрядами
+func formatNumber(v uint64) string {
+	s := strconv.FormatUint(v, 10)
+	var sb strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			sb.WriteRune(' ')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+func value(v *uint32) uint32 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
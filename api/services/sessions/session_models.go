@@ -0,0 +1,20 @@
+package sessions_service
+
+import "time"
+
+// ClientInfo данные устройства, с которого выполнен вход
+type ClientInfo struct {
+	DeviceName string
+	UserAgent  string
+	IP         string
+}
+
+type SessionModel struct {
+	SessionID  uint64    `json:"session_id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
@@ -0,0 +1,328 @@
+package sessions_service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"odo24_mobile_backend/api/services"
+	"odo24_mobile_backend/db"
+	"time"
+)
+
+const (
+	maxDeviceNameLength = 255
+	maxUserAgentLength  = 512
+)
+
+const (
+	SecurityEventRefreshTokenReuse = "refresh_token_reuse"
+)
+
+var (
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+type SessionsService struct {
+	denylist        services.TokenDenylist
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+}
+
+/*
+NewSessionsService сессии устройств.
+При завершении сессии uuid ее access токена попадает в denylist на время жизни access токена,
+сессии и замененные токены старше refreshTokenTTL удаляет RunPrune
+*/
+func NewSessionsService(denylist services.TokenDenylist, accessTokenTTL, refreshTokenTTL time.Duration) *SessionsService {
+	return &SessionsService{
+		denylist:        denylist,
+		accessTokenTTL:  accessTokenTTL,
+		refreshTokenTTL: refreshTokenTTL,
+	}
+}
+
+// Create новая сессия устройства, tokenUUID - uuid пары access/refresh токенов
+func (srv *SessionsService) Create(userID uint64, tokenUUID string, client ClientInfo) error {
+	pg := db.Conn()
+
+	tx, err := pg.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var sessionID uint64
+	err = tx.QueryRow(`INSERT INTO profiles.sessions (user_id,token_uuid,device_name,user_agent,ip) VALUES ($1,$2,$3,$4,$5) RETURNING session_id`,
+		userID, tokenUUID, truncate(client.DeviceName, maxDeviceNameLength), truncate(client.UserAgent, maxUserAgentLength), client.IP).Scan(&sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO profiles.session_tokens (token_uuid,session_id) VALUES ($1,$2)`, tokenUUID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+/*
+Rotate замена uuid токенов сессии при рефреше, прежний access токен отзывается.
+Предъявление уже замененного refresh токена означает его утечку:
+сессия целиком завершается, а событие записывается в журнал безопасности
+*/
+func (srv *SessionsService) Rotate(userID uint64, oldTokenUUID, newTokenUUID string, client ClientInfo) error {
+	pg := db.Conn()
+
+	tx, err := pg.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var sessionID uint64
+	err = tx.QueryRow(`SELECT s.session_id FROM profiles.sessions s WHERE s.user_id=$1 AND s.token_uuid=$2 FOR UPDATE`, userID, oldTokenUUID).Scan(&sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			tx.Rollback()
+			return srv.checkReuse(userID, oldTokenUUID, client)
+		}
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE profiles.sessions SET token_uuid=$1,user_agent=$2,ip=$3,last_used_at=now() WHERE session_id=$4`,
+		newTokenUUID, truncate(client.UserAgent, maxUserAgentLength), client.IP, sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE profiles.session_tokens SET rotated_at=now() WHERE token_uuid=$1`, oldTokenUUID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO profiles.session_tokens (token_uuid,session_id,parent_uuid) VALUES ($1,$2,$3)`, newTokenUUID, sessionID, oldTokenUUID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	srv.revokeAccessTokens([]string{oldTokenUUID})
+	return nil
+}
+
+// checkReuse поиск сессии, в которой токен уже был заменен
+func (srv *SessionsService) checkReuse(userID uint64, tokenUUID string, client ClientInfo) error {
+	pg := db.Conn()
+
+	var sessionID uint64
+	err := pg.QueryRow(`SELECT t.session_id FROM profiles.session_tokens t
+		INNER JOIN profiles.sessions s ON s.session_id=t.session_id
+		WHERE t.token_uuid=$1 AND s.user_id=$2 AND t.rotated_at IS NOT NULL`, tokenUUID, userID).Scan(&sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+
+	err = srv.Delete(userID, sessionID)
+	if err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+
+	srv.logSecurityEvent(userID, SecurityEventRefreshTokenReuse, fmt.Sprintf("session_id=%d token_uuid=%s", sessionID, tokenUUID), client)
+	return ErrRefreshTokenReused
+}
+
+// logSecurityEvent запись в журнал безопасности, ошибка записи только логируется
+func (srv *SessionsService) logSecurityEvent(userID uint64, event, details string, client ClientInfo) {
+	log.Printf("security event %s: user_id=%d %s ip=%s", event, userID, details, client.IP)
+
+	pg := db.Conn()
+	_, err := pg.Exec(`INSERT INTO profiles.security_events (user_id,event,details,ip,user_agent) VALUES ($1,$2,$3,$4,$5)`,
+		userID, event, details, client.IP, truncate(client.UserAgent, maxUserAgentLength))
+	if err != nil {
+		log.Printf("security event insert error: %v", err)
+	}
+}
+
+// IsAccessTokenRevoked проверка access токена по denylist
+func (srv *SessionsService) IsAccessTokenRevoked(tokenUUID string) (bool, error) {
+	return srv.denylist.Contains(tokenUUID)
+}
+
+// GetByUser активные сессии пользователя, currentTokenUUID отмечает текущую
+func (srv *SessionsService) GetByUser(userID uint64, currentTokenUUID string) ([]SessionModel, error) {
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT s.session_id,s.token_uuid,s.device_name,s.user_agent,s.ip,s.created_at,s.last_used_at
+		FROM profiles.sessions s
+		WHERE s.user_id=$1
+		ORDER BY s.last_used_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var result []SessionModel
+	for rows.Next() {
+		var model SessionModel
+		var tokenUUID string
+		err := rows.Scan(&model.SessionID, &tokenUUID, &model.DeviceName, &model.UserAgent, &model.IP, &model.CreatedAt, &model.LastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		model.Current = tokenUUID == currentTokenUUID
+
+		result = append(result, model)
+	}
+
+	return result, rows.Err()
+}
+
+func (srv *SessionsService) Delete(userID, sessionID uint64) error {
+	pg := db.Conn()
+
+	var tokenUUID string
+	err := pg.QueryRow(`DELETE FROM profiles.sessions WHERE session_id=$1 AND user_id=$2 RETURNING token_uuid`, sessionID, userID).Scan(&tokenUUID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+
+	srv.revokeAccessTokens([]string{tokenUUID})
+	return nil
+}
+
+// DeleteByTokenUUID завершение сессии по uuid ее токенов
+func (srv *SessionsService) DeleteByTokenUUID(userID uint64, tokenUUID string) error {
+	pg := db.Conn()
+
+	_, err := pg.Exec(`DELETE FROM profiles.sessions WHERE user_id=$1 AND token_uuid=$2`, userID, tokenUUID)
+	if err != nil {
+		return err
+	}
+
+	srv.revokeAccessTokens([]string{tokenUUID})
+	return nil
+}
+
+// DeleteOthers завершение всех сессий пользователя, кроме текущей
+func (srv *SessionsService) DeleteOthers(userID uint64, currentTokenUUID string) error {
+	return srv.deleteWhere(`DELETE FROM profiles.sessions WHERE user_id=$1 AND token_uuid<>$2 RETURNING token_uuid`, userID, currentTokenUUID)
+}
+
+// DeleteAll завершение всех сессий пользователя
+func (srv *SessionsService) DeleteAll(userID uint64) error {
+	return srv.deleteWhere(`DELETE FROM profiles.sessions WHERE user_id=$1 RETURNING token_uuid`, userID)
+}
+
+/*
+DeleteAllTx удаление всех сессий пользователя в транзакции вызывающего.
+Возвращает uuid токенов, которые после commit нужно передать в RevokeAccessTokens
+*/
+func (srv *SessionsService) DeleteAllTx(tx *sql.Tx, userID uint64) ([]string, error) {
+	rows, err := tx.Query(`DELETE FROM profiles.sessions WHERE user_id=$1 RETURNING token_uuid`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	return scanTokenUUIDs(rows)
+}
+
+/*
+PruneExpired удаление сессий, не обновлявшихся дольше срока refresh токена, и замененных токенов старше этого срока.
+Такой токен уже не пройдет проверку срока, поэтому для обнаружения повторного использования он не нужен.
+Access токены этих сессий давно истекли, в denylist их не добавляем
+*/
+func (srv *SessionsService) PruneExpired() error {
+	pg := db.Conn()
+
+	_, err := pg.Exec(`DELETE FROM profiles.sessions WHERE last_used_at<now()-make_interval(secs => $1)`, srv.refreshTokenTTL.Seconds())
+	if err != nil {
+		return err
+	}
+
+	_, err = pg.Exec(`DELETE FROM profiles.session_tokens WHERE rotated_at IS NOT NULL AND created_at<now()-make_interval(secs => $1)`, srv.refreshTokenTTL.Seconds())
+	return err
+}
+
+// RunPrune периодический запуск PruneExpired, блокирует вызывающую горутину
+func (srv *SessionsService) RunPrune(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := srv.PruneExpired()
+		if err != nil {
+			log.Printf("prune expired sessions error: %v", err)
+		}
+	}
+}
+
+// RevokeAccessTokens отзыв access токенов уже удаленных сессий
+func (srv *SessionsService) RevokeAccessTokens(tokenUUIDs []string) {
+	srv.revokeAccessTokens(tokenUUIDs)
+}
+
+func (srv *SessionsService) deleteWhere(query string, args ...interface{}) error {
+	pg := db.Conn()
+
+	rows, err := pg.Query(query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	tokenUUIDs, err := scanTokenUUIDs(rows)
+	if err != nil {
+		return err
+	}
+
+	srv.revokeAccessTokens(tokenUUIDs)
+	return nil
+}
+
+func scanTokenUUIDs(rows *sql.Rows) ([]string, error) {
+	var tokenUUIDs []string
+	for rows.Next() {
+		var tokenUUID string
+		err := rows.Scan(&tokenUUID)
+		if err != nil {
+			return nil, err
+		}
+		tokenUUIDs = append(tokenUUIDs, tokenUUID)
+	}
+	return tokenUUIDs, rows.Err()
+}
+
+// revokeAccessTokens сессии уже удалены, поэтому ошибка denylist только логируется
+func (srv *SessionsService) revokeAccessTokens(tokenUUIDs []string) {
+	for _, tokenUUID := range tokenUUIDs {
+		err := srv.denylist.Add(tokenUUID, srv.accessTokenTTL)
+		if err != nil {
+			log.Printf("denylist add token %s error: %v", tokenUUID, err)
+		}
+	}
+}
+
+func truncate(value string, maxLength int) string {
+	runes := []rune(value)
+	if len(runes) > maxLength {
+		return string(runes[:maxLength])
+	}
+	return value
+}
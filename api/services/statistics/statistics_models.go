@@ -0,0 +1,39 @@
+package statistics_service
+
+type StatisticsModel struct {
+	TotalCost    uint64 `json:"total_cost"`
+	RecordsCount uint64 `json:"records_count"`
+	PricedCount  uint64 `json:"priced_count"`
+	// Distance суммарный пробег по записям с пробегом, от первой записи до текущего пробега авто
+	Distance      uint64                 `json:"distance"`
+	CostPer1000Km *float64               `json:"cost_per_1000_km"`
+	ByYear        []PeriodCostModel      `json:"by_year"`
+	ByMonth       []PeriodCostModel      `json:"by_month"`
+	ByGroup       []GroupCostModel       `json:"by_group"`
+	MostExpensive []ExpensiveRecordModel `json:"most_expensive"`
+}
+
+type PeriodCostModel struct {
+	Period string `json:"period"`
+	Total  uint64 `json:"total"`
+	Count  uint64 `json:"count"`
+}
+
+type GroupCostModel struct {
+	GroupID   uint64 `json:"group_id"`
+	GroupName string `json:"group_name"`
+	Total     uint64 `json:"total"`
+	Count     uint64 `json:"count"`
+}
+
+type ExpensiveRecordModel struct {
+	ServiceID   uint64  `json:"service_id"`
+	CarID       uint64  `json:"car_id"`
+	CarName     string  `json:"car_name"`
+	GroupID     uint64  `json:"group_id"`
+	GroupName   string  `json:"group_name"`
+	Dt          string  `json:"dt"`
+	Odo         *uint32 `json:"odo"`
+	Price       uint32  `json:"price"`
+	Description *string `json:"description"`
+}
@@ -0,0 +1,180 @@
+package statistics_service
+
+import (
+	"odo24_mobile_backend/db"
+)
+
+const mostExpensiveLimit = 10
+
+// scopeCondition записи авто пользователя, $2 - необязательный фильтр по авто
+const scopeCondition = `c.user_id=$1 AND ($2::bigint IS NULL OR s.car_id=$2)`
+
+type StatisticsService struct{}
+
+func NewStatisticsService() *StatisticsService {
+	return &StatisticsService{}
+}
+
+// GetByUser статистика расходов по всем авто пользователя
+func (srv *StatisticsService) GetByUser(userID uint64) (*StatisticsModel, error) {
+	return srv.get(userID, nil)
+}
+
+// GetByCar статистика расходов одного авто
+func (srv *StatisticsService) GetByCar(userID, carID uint64) (*StatisticsModel, error) {
+	return srv.get(userID, &carID)
+}
+
+func (srv *StatisticsService) get(userID uint64, carID *uint64) (*StatisticsModel, error) {
+	pg := db.Conn()
+
+	stat := StatisticsModel{}
+	err := pg.QueryRow(`SELECT count(*),count(s.price),coalesce(sum(s.price),0)
+		FROM service_book.services s
+		INNER JOIN service_book.car c ON c.car_id=s.car_id
+		WHERE `+scopeCondition, userID, carID).Scan(&stat.RecordsCount, &stat.PricedCount, &stat.TotalCost)
+	if err != nil {
+		return nil, err
+	}
+
+	stat.ByYear, err = srv.byPeriod(userID, carID, "year", "YYYY")
+	if err != nil {
+		return nil, err
+	}
+
+	stat.ByMonth, err = srv.byPeriod(userID, carID, "month", "YYYY-MM")
+	if err != nil {
+		return nil, err
+	}
+
+	stat.ByGroup, err = srv.byGroup(userID, carID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv.costPerDistance(userID, carID, &stat)
+	if err != nil {
+		return nil, err
+	}
+
+	stat.MostExpensive, err = srv.mostExpensive(userID, carID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stat, nil
+}
+
+// byPeriod расходы по месяцам или годам, period - единица date_trunc, format - формат to_char
+func (srv *StatisticsService) byPeriod(userID uint64, carID *uint64, period, format string) ([]PeriodCostModel, error) {
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT to_char(date_trunc($3, s.dt), $4) p,coalesce(sum(s.price),0),count(*)
+		FROM service_book.services s
+		INNER JOIN service_book.car c ON c.car_id=s.car_id
+		WHERE `+scopeCondition+`
+		GROUP BY p
+		ORDER BY p`, userID, carID, period, format)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := []PeriodCostModel{}
+	for rows.Next() {
+		var model PeriodCostModel
+		err := rows.Scan(&model.Period, &model.Total, &model.Count)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, model)
+	}
+
+	return result, rows.Err()
+}
+
+func (srv *StatisticsService) byGroup(userID uint64, carID *uint64) ([]GroupCostModel, error) {
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT g.group_id,g."name",coalesce(sum(s.price),0) total,count(*)
+		FROM service_book.services s
+		INNER JOIN service_book.car c ON c.car_id=s.car_id
+		INNER JOIN service_book.service_groups g ON g.group_id=s.group_id
+		WHERE `+scopeCondition+`
+		GROUP BY g.group_id,g."name"
+		ORDER BY total DESC,g."name"`, userID, carID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := []GroupCostModel{}
+	for rows.Next() {
+		var model GroupCostModel
+		err := rows.Scan(&model.GroupID, &model.GroupName, &model.Total, &model.Count)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, model)
+	}
+
+	return result, rows.Err()
+}
+
+/*
+costPerDistance средняя стоимость 1000 км. Пробег авто - от наименьшего пробега в записях
+до текущего пробега авто (или наибольшего в записях), учитываются только авто с ненулевым пробегом
+*/
+func (srv *StatisticsService) costPerDistance(userID uint64, carID *uint64, stat *StatisticsModel) error {
+	pg := db.Conn()
+
+	var cost uint64
+	err := pg.QueryRow(`SELECT coalesce(sum(t.span),0),coalesce(sum(t.cost),0) FROM (
+			SELECT greatest(max(s.odo),max(c.odo))-min(s.odo) span,coalesce(sum(s.price),0) cost
+			FROM service_book.services s
+			INNER JOIN service_book.car c ON c.car_id=s.car_id
+			WHERE `+scopeCondition+`
+			GROUP BY s.car_id
+		) t
+		WHERE t.span>0`, userID, carID).Scan(&stat.Distance, &cost)
+	if err != nil {
+		return err
+	}
+
+	if stat.Distance > 0 {
+		costPer1000Km := float64(cost) * 1000 / float64(stat.Distance)
+		stat.CostPer1000Km = &costPer1000Km
+	}
+	return nil
+}
+
+func (srv *StatisticsService) mostExpensive(userID uint64, carID *uint64) ([]ExpensiveRecordModel, error) {
+	pg := db.Conn()
+
+	rows, err := pg.Query(`SELECT s.service_id,s.car_id,c."name",s.group_id,g."name",s.dt,s.odo,s.price,s.description
+		FROM service_book.services s
+		INNER JOIN service_book.car c ON c.car_id=s.car_id
+		INNER JOIN service_book.service_groups g ON g.group_id=s.group_id
+		WHERE `+scopeCondition+` AND s.price IS NOT NULL
+		ORDER BY s.price DESC,s.dt DESC
+		LIMIT $3`, userID, carID, mostExpensiveLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	result := []ExpensiveRecordModel{}
+	for rows.Next() {
+		var model ExpensiveRecordModel
+		err := rows.Scan(&model.ServiceID, &model.CarID, &model.CarName, &model.GroupID, &model.GroupName, &model.Dt, &model.Odo, &model.Price, &model.Description)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, model)
+	}
+
+	return result, rows.Err()
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	DenylistStoreMemcache = "memcache"
+	DenylistStoreMemory   = "memory"
+
+	denylistKeyPrefix = "denylist."
+)
+
+// TokenDenylist отозванные до истечения срока access токены, ключ - uuid токена
+type TokenDenylist interface {
+	Add(tokenUUID string, ttl time.Duration) error
+	Contains(tokenUUID string) (bool, error)
+}
+
+// NewTokenDenylist хранилище по имени из настроек, по умолчанию memcache
+func NewTokenDenylist(store string, memc *memcache.Client) TokenDenylist {
+	if store == DenylistStoreMemory {
+		return NewMemoryTokenDenylist()
+	}
+	return NewMemcacheTokenDenylist(memc)
+}
+
+type MemcacheTokenDenylist struct {
+	memc *memcache.Client
+}
+
+func NewMemcacheTokenDenylist(memc *memcache.Client) *MemcacheTokenDenylist {
+	return &MemcacheTokenDenylist{
+		memc: memc,
+	}
+}
+
+func (d *MemcacheTokenDenylist) Add(tokenUUID string, ttl time.Duration) error {
+	return d.memc.Set(&memcache.Item{
+		Key:        denylistKeyPrefix + tokenUUID,
+		Value:      []byte{1},
+		Expiration: int32(ttl.Seconds()) + 1,
+	})
+}
+
+func (d *MemcacheTokenDenylist) Contains(tokenUUID string) (bool, error) {
+	_, err := d.memc.Get(denylistKeyPrefix + tokenUUID)
+	if err != nil {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// MemoryTokenDenylist хранилище в памяти процесса, для тестов и запуска без memcached
+type MemoryTokenDenylist struct {
+	mx    sync.Mutex
+	items map[string]time.Time
+}
+
+func NewMemoryTokenDenylist() *MemoryTokenDenylist {
+	return &MemoryTokenDenylist{
+		items: make(map[string]time.Time),
+	}
+}
+
+func (d *MemoryTokenDenylist) Add(tokenUUID string, ttl time.Duration) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	now := time.Now()
+	for key, expiration := range d.items {
+		if now.After(expiration) {
+			delete(d.items, key)
+		}
+	}
+
+	d.items[tokenUUID] = now.Add(ttl)
+	return nil
+}
+
+func (d *MemoryTokenDenylist) Contains(tokenUUID string) (bool, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	expiration, ok := d.items[tokenUUID]
+	if !ok {
+		return false, nil
+	}
+	return time.Now().Before(expiration), nil
+}
@@ -0,0 +1,13 @@
+package verification_service
+
+// SendCodeResultModel StatusToken - параметр token для проверки состояния отправленного кода
+type SendCodeResultModel struct {
+	StatusToken string `json:"status_token"`
+}
+
+type CodeStatusModel struct {
+	Sent         bool `json:"sent"`
+	ExpiresIn    int  `json:"expires_in"`
+	ResendAfter  int  `json:"resend_after"`
+	AttemptsLeft int  `json:"attempts_left"`
+}
@@ -0,0 +1,301 @@
+package verification_service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"odo24_mobile_backend/api/services"
+	"strings"
+	"time"
+)
+
+const (
+	codeKeyPrefix = "code."
+	// maxSwapRetries повторы проверки кода при одновременных попытках
+	maxSwapRetries = 10
+)
+
+// Purpose назначение кода, коды разных назначений хранятся независимо
+type Purpose string
+
+const (
+	PurposeRegister      Purpose = "register"
+	PurposeRecover       Purpose = "recover"
+	PurposeEmailChange   Purpose = "email_change"
+	PurposeAccountDelete Purpose = "account_delete"
+)
+
+type purposeOptions struct {
+	TTL            time.Duration
+	ResendCooldown time.Duration
+	MaxAttempts    int
+}
+
+var purposes = map[Purpose]purposeOptions{
+	PurposeRegister:      {TTL: time.Minute * 10, ResendCooldown: time.Minute, MaxAttempts: 5},
+	PurposeRecover:       {TTL: time.Minute * 10, ResendCooldown: time.Minute * 2, MaxAttempts: 5},
+	PurposeEmailChange:   {TTL: time.Minute * 15, ResendCooldown: time.Minute, MaxAttempts: 5},
+	PurposeAccountDelete: {TTL: time.Minute * 10, ResendCooldown: time.Minute, MaxAttempts: 3},
+}
+
+var (
+	ErrUnknownPurpose   = errors.New("unknown code purpose")
+	ErrCodeDoesNotMatch = errors.New("code does not match")
+	ErrResendTooEarly   = errors.New("code has already been sent")
+	ErrCodeConflict     = errors.New("code state update conflict")
+)
+
+// ResendCooldownError код уже отправлен, новый можно запросить через RetryAfter
+type ResendCooldownError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ResendCooldownError) Error() string {
+	return fmt.Sprintf("code has already been sent, retry after %s", e.RetryAfter)
+}
+
+func (e *ResendCooldownError) Is(target error) bool {
+	return target == ErrResendTooEarly
+}
+
+// codeEntry StatusToken выдается отправившему код, без него состояние кода не раскрывается
+type codeEntry struct {
+	Code        string    `json:"code"`
+	StatusToken string    `json:"status_token"`
+	Attempts    int       `json:"attempts"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type VerificationService struct {
+	store services.CodeStore
+}
+
+func NewVerificationService(store services.CodeStore) *VerificationService {
+	return &VerificationService{
+		store: store,
+	}
+}
+
+// ParsePurpose назначение из параметра запроса
+func ParsePurpose(value string) (Purpose, error) {
+	purpose := Purpose(value)
+	if _, ok := purposes[purpose]; !ok {
+		return "", ErrUnknownPurpose
+	}
+	return purpose, nil
+}
+
+/*
+Issue новый код для subject (email или идентификатор пользователя).
+Повторный запрос раньше ResendCooldown возвращает ResendCooldownError, позже - заменяет прежний код.
+Запись через Add или CompareAndSwap: из одновременных запросов код выдается только одному,
+а новый код наследует неверные попытки прежнего, повторная отправка не дает лишних попыток.
+statusToken - пропуск к Status для отправившего запрос
+*/
+func (srv *VerificationService) Issue(purpose Purpose, subject string) (code, statusToken string, err error) {
+	options, ok := purposes[purpose]
+	if !ok {
+		return "", "", ErrUnknownPurpose
+	}
+
+	code, err = generateCode()
+	if err != nil {
+		return "", "", err
+	}
+	statusToken, err = generateToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	key := codeKey(purpose, subject)
+	for i := 0; i < maxSwapRetries; i++ {
+		entry, old, err := srv.get(key)
+		if err != nil {
+			return "", "", err
+		}
+
+		now := time.Now()
+		attempts := 0
+		if entry != nil {
+			resendAt := entry.CreatedAt.Add(options.ResendCooldown)
+			if now.Before(resendAt) {
+				return "", "", &ResendCooldownError{RetryAfter: resendAt.Sub(now)}
+			}
+			attempts = entry.Attempts
+		}
+
+		next := &codeEntry{
+			Code:        code,
+			StatusToken: statusToken,
+			Attempts:    attempts,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(options.TTL),
+		}
+		value, err := json.Marshal(next)
+		if err != nil {
+			return "", "", err
+		}
+
+		var stored bool
+		if old == nil {
+			stored, err = srv.store.Add(key, value, entryTTL(next))
+		} else {
+			stored, err = srv.store.CompareAndSwap(key, old, value, entryTTL(next))
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if stored {
+			return code, statusToken, nil
+		}
+	}
+
+	return "", "", ErrCodeConflict
+}
+
+/*
+Verify проверка кода. Верный код удаляется,
+после MaxAttempts неверных попыток код аннулируется и нужно запросить новый.
+Изменения через CompareAndSwap: параллельные попытки не теряют счетчик и не используют код дважды
+*/
+func (srv *VerificationService) Verify(purpose Purpose, subject, code string) error {
+	options, ok := purposes[purpose]
+	if !ok {
+		return ErrUnknownPurpose
+	}
+
+	key := codeKey(purpose, subject)
+	for i := 0; i < maxSwapRetries; i++ {
+		entry, old, err := srv.get(key)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return ErrCodeDoesNotMatch
+		}
+
+		var next []byte
+		matched := subtle.ConstantTimeCompare([]byte(entry.Code), []byte(strings.TrimSpace(code))) == 1
+		if !matched {
+			entry.Attempts++
+			if entry.Attempts < options.MaxAttempts {
+				next, err = json.Marshal(entry)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		swapped, err := srv.store.CompareAndSwap(key, old, next, entryTTL(entry))
+		if err != nil {
+			return err
+		}
+		if !swapped {
+			continue
+		}
+
+		if matched {
+			return nil
+		}
+		return ErrCodeDoesNotMatch
+	}
+
+	return ErrCodeConflict
+}
+
+// Discard удаление кода, например если письмо с ним не удалось отправить
+func (srv *VerificationService) Discard(purpose Purpose, subject string) error {
+	return srv.store.Delete(codeKey(purpose, subject))
+}
+
+/*
+Status состояние кода, чтобы приложение знало, когда можно запросить повторную отправку.
+С чужим statusToken ответ тот же, что и для неотправленного кода, иначе по нему можно перебирать адреса
+*/
+func (srv *VerificationService) Status(purpose Purpose, subject, statusToken string) (*CodeStatusModel, error) {
+	options, ok := purposes[purpose]
+	if !ok {
+		return nil, ErrUnknownPurpose
+	}
+
+	entry, _, err := srv.get(codeKey(purpose, subject))
+	if err != nil {
+		return nil, err
+	}
+
+	status := CodeStatusModel{}
+	if entry == nil || statusToken == "" || subtle.ConstantTimeCompare([]byte(entry.StatusToken), []byte(statusToken)) != 1 {
+		return &status, nil
+	}
+
+	now := time.Now()
+	status.Sent = true
+	status.ExpiresIn = secondsUntil(now, entry.ExpiresAt)
+	status.ResendAfter = secondsUntil(now, entry.CreatedAt.Add(options.ResendCooldown))
+	status.AttemptsLeft = options.MaxAttempts - entry.Attempts
+
+	return &status, nil
+}
+
+// get код и его исходное значение в хранилище для CompareAndSwap, у истекшего кода entry nil, а значение есть
+func (srv *VerificationService) get(key string) (*codeEntry, []byte, error) {
+	value, err := srv.store.Get(key)
+	if err != nil || value == nil {
+		return nil, nil, err
+	}
+
+	var entry codeEntry
+	err = json.Unmarshal(value, &entry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if time.Now().After(entry.ExpiresAt) {
+		return nil, value, nil
+	}
+	return &entry, value, nil
+}
+
+func entryTTL(entry *codeEntry) time.Duration {
+	ttl := time.Until(entry.ExpiresAt)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
+
+func codeKey(purpose Purpose, subject string) string {
+	return codeKeyPrefix + string(purpose) + "." + strings.ToLower(subject)
+}
+
+// generateCode криптографически случайный код из 4 цифр без ведущего нуля
+func generateCode() (string, error) {
+	value, err := rand.Int(rand.Reader, big.NewInt(9000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(value.Int64() + 1000), nil
+}
+
+// generateToken случайный токен для проверки состояния кода
+func generateToken() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func secondsUntil(now, t time.Time) int {
+	if !t.After(now) {
+		return 0
+	}
+	return int(math.Ceil(t.Sub(now).Seconds()))
+}
@@ -0,0 +1,40 @@
+package verification_service
+
+import (
+	"errors"
+	"odo24_mobile_backend/api/services"
+	"sync"
+	"testing"
+)
+
+// TestIssueConcurrent из одновременных запросов код выдается одному, остальные получают ResendCooldownError
+func TestIssueConcurrent(t *testing.T) {
+	srv := NewVerificationService(services.NewMemoryCodeStore())
+
+	const requests = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := srv.Issue(PurposeRegister, "user@example.com")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	issued := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			issued++
+		case !errors.Is(err, ErrResendTooEarly):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if issued != 1 {
+		t.Fatalf("issued %d codes, want 1", issued)
+	}
+}
@@ -1,18 +1,161 @@
 package utils
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
 )
 
 const saltSize = 16
 
+const argon2idPrefix = "$argon2id$"
+
+var ErrInvalidPasswordHash = errors.New("invalid password hash format")
+
+// Argon2Params параметры argon2id
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params рекомендуемые параметры OWASP
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	KeyLength:   32,
+}
+
+/*
+PasswordHasher хеширование паролей.
+Новые хеши - argon2id в формате PHC ($argon2id$v=19$m=...,t=...,p=...$salt$key),
+старые sha256(salt+password) со своей солью только проверяются
+*/
+type PasswordHasher struct {
+	params Argon2Params
+}
+
+func NewPasswordHasher(params Argon2Params) *PasswordHasher {
+	if params.Memory == 0 {
+		params.Memory = DefaultArgon2Params.Memory
+	}
+	if params.Iterations == 0 {
+		params.Iterations = DefaultArgon2Params.Iterations
+	}
+	if params.Parallelism == 0 {
+		params.Parallelism = DefaultArgon2Params.Parallelism
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = DefaultArgon2Params.KeyLength
+	}
+	return &PasswordHasher{
+		params: params,
+	}
+}
+
+// Hash хеш пароля с текущими параметрами
+func (h *PasswordHasher) Hash(password string) ([]byte, error) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		h.params.Memory,
+		h.params.Iterations,
+		h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+	return []byte(encoded), nil
+}
+
+/*
+Verify проверка пароля.
+legacySalt используется только для старых sha256 хешей.
+needRehash=true если пароль верный, но хеш устарел (sha256 или другие параметры argon2id)
+*/
+func (h *PasswordHasher) Verify(password string, hash, legacySalt []byte) (ok bool, needRehash bool, err error) {
+	if len(hash) == 0 {
+		return false, false, nil
+	}
+
+	if !bytes.HasPrefix(hash, []byte(argon2idPrefix)) {
+		legacyHash, err := GetPasswordHash([]byte(password), legacySalt)
+		if err != nil {
+			return false, false, err
+		}
+		ok = subtle.ConstantTimeCompare(legacyHash, hash) == 1
+		return ok, ok, nil
+	}
+
+	params, salt, key, err := decodeArgon2idHash(string(hash))
+	if err != nil {
+		return false, false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return false, false, nil
+	}
+
+	return true, params != h.params, nil
+}
+
+func decodeArgon2idHash(encoded string) (params Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: unsupported argon2 version %d", ErrInvalidPasswordHash, version)
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
 func GenerateSalt() ([]byte, error) {
 	salt := make([]byte, saltSize)
 	_, err := rand.Read(salt)
 	return salt, err
 }
 
+// GetPasswordHash устаревший хеш sha256(salt+password), только для проверки старых паролей
 func GetPasswordHash(password []byte, salt []byte) ([]byte, error) {
 	hasherNewPassword := sha256.New()
 	_, err := hasherNewPassword.Write(salt)
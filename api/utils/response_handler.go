@@ -2,7 +2,10 @@ package utils
 
 import (
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -45,6 +48,16 @@ func BindBadRequestWithAbort(c *gin.Context, message string, err error) {
 	c.Abort()
 }
 
+// BindTooManyRequestsWithAbort 429 с заголовком Retry-After в секундах
+func BindTooManyRequestsWithAbort(c *gin.Context, key, message string, retryAfter time.Duration, err error) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	BindErrorWithAbort(c, http.StatusTooManyRequests, key, message, err)
+}
+
 func BindServiceErrorWithAbort(c *gin.Context, key, message string, err error) {
 	BindErrorWithAbort(c, http.StatusInternalServerError, key, message, err)
 }
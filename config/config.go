@@ -13,6 +13,26 @@ type Oauth struct {
 	ClientID     string `json:"ClientID"`
 	ClientSecret string `json:"ClientSecret"`
 	RedirectURI  string `json:"RedirectURI"`
+	UserInfoURL  string `json:"UserInfoURL"`
+}
+
+type OauthProviders struct {
+	MailRu Oauth `json:"mailru"`
+	Yandex Oauth `json:"yandex"`
+}
+
+// JwtKey RSA ключ подписи токенов, private_key_path нужен только активному ключу
+type JwtKey struct {
+	Kid            string `json:"kid"`
+	PrivateKeyPath string `json:"private_key_path"`
+	PublicKeyPath  string `json:"public_key_path"`
+	NotAfter       string `json:"not_after"`
+}
+
+// JwtKeyRing набор ключей, active_kid - ключ для подписи новых токенов
+type JwtKeyRing struct {
+	ActiveKid string   `json:"active_kid"`
+	Keys      []JwtKey `json:"keys"`
 }
 
 // Configuration структура конфига
@@ -25,12 +45,61 @@ type Configuration struct {
 		JwtRefreshPrivateKeyPath string `json:"jwt_refresh_private_key_path"`
 		JwtRefreshPublicKeyPath  string `json:"jwt_refresh_public_key_path"`
 	} `json:"app"`
+	Jwt struct {
+		Access  JwtKeyRing `json:"access"`
+		Refresh JwtKeyRing `json:"refresh"`
+	} `json:"jwt"`
+	Auth struct {
+		DenylistStore string `json:"denylist_store"`
+		// DenylistFailOpen пропускать запросы при недоступности хранилища отозванных токенов
+		DenylistFailOpen bool   `json:"denylist_fail_open"`
+		AttemptsStore    string `json:"attempts_store"`
+		CodeStore        string `json:"code_store"`
+	} `json:"auth"`
+	Account struct {
+		DeletionGraceDays int `json:"deletion_grace_days"`
+	} `json:"account"`
+	Export struct {
+		Dir string `json:"dir"`
+	} `json:"export"`
+	Reminders struct {
+		Enabled         bool   `json:"enabled"`
+		IntervalMinutes int    `json:"interval_minutes"`
+		DistanceKm      uint32 `json:"distance_km"`
+		Days            int    `json:"days"`
+		MaxOverdueKm    uint32 `json:"max_overdue_km"`
+		MaxOverdueDays  int    `json:"max_overdue_days"`
+	} `json:"reminders"`
+	Push struct {
+		Endpoint    string `json:"endpoint"`
+		AccessToken string `json:"access_token"`
+		// CredentialsFile ключ сервисного аккаунта Google для FCM HTTP v1
+		CredentialsFile string `json:"credentials_file"`
+		TimeoutSeconds  int    `json:"timeout_seconds"`
+		MaxAttempts     int    `json:"max_attempts"`
+	} `json:"push"`
+	Report struct {
+		FontPath string `json:"font_path"`
+	} `json:"report"`
+	PasswordHash struct {
+		Memory      uint32 `json:"memory"`
+		Iterations  uint32 `json:"iterations"`
+		Parallelism uint8  `json:"parallelism"`
+	} `json:"password_hash"`
 	SMTP struct {
 		Host     string `json:"host"`
 		Port     uint16 `json:"port"`
 		From     string `json:"from"`
 		Password string `json:"password"`
 	} `json:"smtp"`
+	Mail struct {
+		Transport string `json:"transport"`
+		Dir       string `json:"dir"`
+	} `json:"mail"`
+	Outbox struct {
+		Workers     int `json:"workers"`
+		MaxAttempts int `json:"max_attempts"`
+	} `json:"outbox"`
 	Memcache struct {
 		Addr string `json:"addr"`
 	} `json:"memcache"`
@@ -40,10 +109,7 @@ type Configuration struct {
 		MaxIdleConns     int    `json:"max_idle_conns"`
 		MaxOpenConns     int    `json:"max_open_conns"`
 	} `json:"db"`
-	Oauth struct {
-		MailRu Oauth `json:"mailru"`
-		Yandex Oauth `json:"yandex"`
-	} `json:"oauth"`
+	Oauth OauthProviders `json:"oauth"`
 }
 
 var cfg *Configuration
@@ -60,6 +126,26 @@ func GetInstance() Configuration {
 	return *cfg
 }
 
+// AccessKeyRing ключи access токенов, при пустой секции jwt - ключ из app
+func (cfg Configuration) AccessKeyRing() JwtKeyRing {
+	if len(cfg.Jwt.Access.Keys) > 0 {
+		return cfg.Jwt.Access
+	}
+	return JwtKeyRing{
+		Keys: []JwtKey{{PrivateKeyPath: cfg.App.JwtAccessPrivateKeyPath, PublicKeyPath: cfg.App.JwtAccessPublicKeyPath}},
+	}
+}
+
+// RefreshKeyRing ключи refresh токенов, при пустой секции jwt - ключ из app
+func (cfg Configuration) RefreshKeyRing() JwtKeyRing {
+	if len(cfg.Jwt.Refresh.Keys) > 0 {
+		return cfg.Jwt.Refresh
+	}
+	return JwtKeyRing{
+		Keys: []JwtKey{{PrivateKeyPath: cfg.App.JwtRefreshPrivateKeyPath, PublicKeyPath: cfg.App.JwtRefreshPublicKeyPath}},
+	}
+}
+
 func (cfg *Configuration) read() {
 	body, err := os.ReadFile(configFilName)
 	if err != nil {
@@ -0,0 +1,11 @@
+-- привязка аккаунтов внешних OAuth провайдеров к пользователям
+CREATE TABLE IF NOT EXISTS profiles.oauth_accounts (
+	provider varchar(32) NOT NULL,
+	external_id varchar(128) NOT NULL,
+	user_id bigint NOT NULL REFERENCES profiles.users (user_id) ON DELETE CASCADE,
+	email varchar(255) NOT NULL,
+	created_at timestamp without time zone NOT NULL DEFAULT now(),
+	PRIMARY KEY (provider, external_id)
+);
+
+CREATE INDEX IF NOT EXISTS oauth_accounts_user_id_idx ON profiles.oauth_accounts (user_id);
@@ -0,0 +1,20 @@
+-- сессии устройств, у каждого устройства своя пара токенов
+CREATE TABLE IF NOT EXISTS profiles.sessions (
+	session_id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL REFERENCES profiles.users (user_id) ON DELETE CASCADE,
+	token_uuid uuid NOT NULL UNIQUE,
+	device_name varchar(255) NOT NULL DEFAULT '',
+	user_agent varchar(512) NOT NULL DEFAULT '',
+	ip varchar(64) NOT NULL DEFAULT '',
+	created_at timestamp without time zone NOT NULL DEFAULT now(),
+	last_used_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON profiles.sessions (user_id);
+
+-- перенос действующих токенов, чтобы не разлогинить пользователей
+INSERT INTO profiles.sessions (user_id, token_uuid, created_at, last_used_at)
+SELECT u.user_id, u.token_uuid::uuid, coalesce(u.last_login_dt, now()), coalesce(u.last_login_dt, now())
+FROM profiles.users u
+WHERE u.token_uuid IS NOT NULL AND u.token_uuid::text <> ''
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,28 @@
+-- цепочка refresh токенов сессии для обнаружения повторного использования
+CREATE TABLE IF NOT EXISTS profiles.session_tokens (
+	token_uuid uuid PRIMARY KEY,
+	session_id bigint NOT NULL REFERENCES profiles.sessions (session_id) ON DELETE CASCADE,
+	parent_uuid uuid,
+	created_at timestamp without time zone NOT NULL DEFAULT now(),
+	rotated_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS session_tokens_session_id_idx ON profiles.session_tokens (session_id);
+
+-- текущие токены открытых сессий - корни цепочек, иначе повторное использование старого токена не обнаруживается
+INSERT INTO profiles.session_tokens (token_uuid, session_id, created_at)
+SELECT s.token_uuid, s.session_id, s.last_used_at
+FROM profiles.sessions s
+ON CONFLICT (token_uuid) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS profiles.security_events (
+	event_id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL REFERENCES profiles.users (user_id) ON DELETE CASCADE,
+	event varchar(64) NOT NULL,
+	details text NOT NULL DEFAULT '',
+	ip varchar(64) NOT NULL DEFAULT '',
+	user_agent varchar(512) NOT NULL DEFAULT '',
+	created_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS security_events_user_id_idx ON profiles.security_events (user_id);
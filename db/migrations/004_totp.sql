@@ -0,0 +1,15 @@
+-- двухфакторная авторизация TOTP
+ALTER TABLE profiles.users
+	ADD COLUMN IF NOT EXISTS totp_secret varchar(64),
+	ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false,
+	ADD COLUMN IF NOT EXISTS totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS profiles.recovery_codes (
+	code_id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL REFERENCES profiles.users (user_id) ON DELETE CASCADE,
+	code_hash bytea NOT NULL,
+	created_at timestamp without time zone NOT NULL DEFAULT now(),
+	used_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON profiles.recovery_codes (user_id);
@@ -0,0 +1,8 @@
+-- коды подтверждения при code_store=postgres
+CREATE TABLE IF NOT EXISTS profiles.verification_codes (
+	key varchar(255) PRIMARY KEY,
+	value bytea NOT NULL,
+	expires_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS verification_codes_expires_at_idx ON profiles.verification_codes (expires_at);
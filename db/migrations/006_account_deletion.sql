@@ -0,0 +1,5 @@
+-- удаление аккаунта с отсрочкой, вход до delete_after отменяет удаление
+ALTER TABLE profiles.users
+	ADD COLUMN IF NOT EXISTS delete_after timestamp without time zone;
+
+CREATE INDEX IF NOT EXISTS users_delete_after_idx ON profiles.users (delete_after) WHERE delete_after IS NOT NULL;
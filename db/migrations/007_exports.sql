@@ -0,0 +1,11 @@
+-- фоновые выгрузки данных пользователя
+CREATE TABLE IF NOT EXISTS profiles.exports (
+	export_id uuid PRIMARY KEY,
+	user_id bigint NOT NULL REFERENCES profiles.users (user_id) ON DELETE CASCADE,
+	status varchar(16) NOT NULL DEFAULT 'pending',
+	error text NOT NULL DEFAULT '',
+	created_at timestamp without time zone NOT NULL DEFAULT now(),
+	finished_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS exports_user_id_idx ON profiles.exports (user_id);
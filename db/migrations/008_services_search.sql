@@ -0,0 +1,3 @@
+-- полнотекстовый поиск по описаниям записей
+CREATE INDEX IF NOT EXISTS services_description_fts_idx ON service_book.services
+	USING gin (to_tsvector('russian', coalesce(description, '')));
@@ -0,0 +1,10 @@
+-- история показаний одометра для прогноза среднего суточного пробега
+CREATE TABLE IF NOT EXISTS service_book.odo_history (
+	odo_history_id bigserial PRIMARY KEY,
+	car_id bigint NOT NULL REFERENCES service_book.car (car_id) ON DELETE CASCADE,
+	odo integer NOT NULL,
+	source varchar(16) NOT NULL,
+	recorded_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS odo_history_car_id_recorded_at_idx ON service_book.odo_history (car_id, recorded_at);
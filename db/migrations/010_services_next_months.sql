@@ -0,0 +1,3 @@
+-- интервал обслуживания по времени, наступает по пробегу или по времени - что раньше
+ALTER TABLE service_book.services
+	ADD COLUMN IF NOT EXISTS next_months integer;
@@ -0,0 +1,6 @@
+-- отправленные напоминания об обслуживании, по последней записи группы напоминание отправляется один раз
+CREATE TABLE IF NOT EXISTS service_book.reminders_sent (
+	service_id bigint PRIMARY KEY REFERENCES service_book.services (service_id) ON DELETE CASCADE,
+	due_by varchar(16) NOT NULL,
+	sent_at timestamp without time zone NOT NULL DEFAULT now()
+);
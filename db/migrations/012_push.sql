@@ -0,0 +1,22 @@
+-- токены push уведомлений устройств, привязаны к сессии и удаляются вместе с ней
+CREATE TABLE IF NOT EXISTS profiles.push_devices (
+	device_id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL REFERENCES profiles.users (user_id) ON DELETE CASCADE,
+	session_id bigint NOT NULL REFERENCES profiles.sessions (session_id) ON DELETE CASCADE,
+	platform varchar(16) NOT NULL,
+	token varchar(4096) NOT NULL UNIQUE,
+	created_at timestamp without time zone NOT NULL DEFAULT now(),
+	updated_at timestamp without time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS push_devices_user_id_idx ON profiles.push_devices (user_id);
+CREATE INDEX IF NOT EXISTS push_devices_session_id_idx ON profiles.push_devices (session_id);
+
+-- настройки уведомлений, отсутствие строки - все уведомления включены
+CREATE TABLE IF NOT EXISTS profiles.notification_preferences (
+	user_id bigint PRIMARY KEY REFERENCES profiles.users (user_id) ON DELETE CASCADE,
+	push_enabled boolean NOT NULL DEFAULT true,
+	reminder_push boolean NOT NULL DEFAULT true,
+	reminder_email boolean NOT NULL DEFAULT true,
+	updated_at timestamp without time zone NOT NULL DEFAULT now()
+);
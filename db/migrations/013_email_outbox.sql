@@ -0,0 +1,16 @@
+-- исходящие письма, отправляются фоновыми воркерами с повторами
+CREATE TABLE IF NOT EXISTS profiles.email_outbox (
+	message_id bigserial PRIMARY KEY,
+	recipient varchar(255) NOT NULL,
+	template varchar(64) NOT NULL,
+	body text NOT NULL,
+	status varchar(16) NOT NULL DEFAULT 'pending',
+	attempts integer NOT NULL DEFAULT 0,
+	last_error text NOT NULL DEFAULT '',
+	next_attempt_at timestamp without time zone NOT NULL DEFAULT now(),
+	locked_until timestamp without time zone,
+	created_at timestamp without time zone NOT NULL DEFAULT now(),
+	sent_at timestamp without time zone
+);
+
+CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON profiles.email_outbox (next_attempt_at) WHERE status IN ('pending', 'sending');
@@ -0,0 +1,8 @@
+-- письма привязаны к пользователю и удаляются вместе с ним, письма с кодами устаревают вместе с кодом
+ALTER TABLE profiles.email_outbox ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES profiles.users (user_id) ON DELETE CASCADE;
+ALTER TABLE profiles.email_outbox ADD COLUMN IF NOT EXISTS expires_at timestamp without time zone;
+
+CREATE INDEX IF NOT EXISTS email_outbox_user_id_idx ON profiles.email_outbox (user_id);
+
+-- тела уже отправленных и неотправляемых писем больше не нужны
+UPDATE profiles.email_outbox SET body='' WHERE status IN ('sent', 'failed') AND body<>'';
@@ -0,0 +1,4 @@
+-- периодическое удаление истекших сессий и замененных refresh токенов
+CREATE INDEX IF NOT EXISTS sessions_last_used_at_idx ON profiles.sessions (last_used_at);
+
+CREATE INDEX IF NOT EXISTS session_tokens_rotated_created_at_idx ON profiles.session_tokens (created_at) WHERE rotated_at IS NOT NULL;
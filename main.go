@@ -37,7 +37,11 @@ func main() {
 	}
 	fmt.Println("OK!")
 
-	sendmail.InitSendmail()
+	newMailer := sendmail.InitSendmail()
+	sendmail.NewOutbox(newMailer, sendmail.OutboxOptions{
+		Workers:     options.Outbox.Workers,
+		MaxAttempts: options.Outbox.MaxAttempts,
+	}).Start()
 
 	// инициализация API методов
 	r := api.InitHandlers()
@@ -0,0 +1,370 @@
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// размер страницы A4 в пунктах
+const (
+	A4Width  = 595.28
+	A4Height = 841.89
+)
+
+/*
+Document простой PDF документ: текст одним шрифтом, линии и заливка прямоугольников.
+Координаты отсчитываются от левого верхнего угла страницы, y текста - базовая линия.
+С TrueType шрифтом текст пишется в кодировке Identity-H и поддерживает кириллицу,
+без шрифта используется стандартный Helvetica, а кириллица транслитерируется
+*/
+type Document struct {
+	width   float64
+	height  float64
+	title   string
+	font    *TrueTypeFont
+	used    map[uint16]rune
+	pages   []*bytes.Buffer
+	current int
+}
+
+// NewDocument документ формата A4, font - nil для Helvetica
+func NewDocument(font *TrueTypeFont) *Document {
+	return &Document{
+		width:  A4Width,
+		height: A4Height,
+		font:   font,
+		used:   make(map[uint16]rune),
+	}
+}
+
+func (d *Document) SetTitle(title string) {
+	d.title = title
+}
+
+func (d *Document) Size() (float64, float64) {
+	return d.width, d.height
+}
+
+// AddPage новая страница становится текущей
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, new(bytes.Buffer))
+	d.current = len(d.pages) - 1
+}
+
+func (d *Document) PageCount() int {
+	return len(d.pages)
+}
+
+// SetPage выбор текущей страницы, например для колонтитулов после верстки
+func (d *Document) SetPage(index int) {
+	if index >= 0 && index < len(d.pages) {
+		d.current = index
+	}
+}
+
+func (d *Document) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	return d.pages[d.current]
+}
+
+// Text строка текста, x - левый край, y - базовая линия
+func (d *Document) Text(x, y, size float64, text string) {
+	if text == "" {
+		return
+	}
+	fmt.Fprintf(d.page(), "BT /F1 %s Tf %s %s Td <%s> Tj ET\n", num(size), num(x), num(d.height-y), d.encode(text))
+}
+
+// TextWidth ширина строки в пунктах
+func (d *Document) TextWidth(text string, size float64) float64 {
+	var width float64
+	if d.font != nil {
+		for _, r := range text {
+			width += d.font.advance(d.glyph(r))
+		}
+	} else {
+		for _, b := range winAnsi(text) {
+			width += helveticaWidth(b)
+		}
+	}
+	return width * size / 1000
+}
+
+// WrapText разбиение текста по словам на строки не шире width
+func (d *Document) WrapText(text string, size, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		words := strings.Fields(paragraph)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+
+		line := ""
+		for _, word := range words {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if line != "" && d.TextWidth(candidate, size) > width {
+				lines = append(lines, line)
+				candidate = word
+			}
+			// слово длиннее строки режется посимвольно
+			for d.TextWidth(candidate, size) > width && len([]rune(candidate)) > 1 {
+				runes := []rune(candidate)
+				cut := len(runes) - 1
+				for cut > 1 && d.TextWidth(string(runes[:cut]), size) > width {
+					cut--
+				}
+				lines = append(lines, string(runes[:cut]))
+				candidate = string(runes[cut:])
+			}
+			line = candidate
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (d *Document) Line(x1, y1, x2, y2, lineWidth float64) {
+	fmt.Fprintf(d.page(), "%s w %s %s m %s %s l S\n", num(lineWidth), num(x1), num(d.height-y1), num(x2), num(d.height-y2))
+}
+
+// FillRect заливка серым, gray от 0 (черный) до 1 (белый)
+func (d *Document) FillRect(x, y, width, height, gray float64) {
+	fmt.Fprintf(d.page(), "%s g %s %s %s %s re f 0 g\n", num(gray), num(x), num(d.height-y-height), num(width), num(height))
+}
+
+func (d *Document) glyph(r rune) uint16 {
+	gid := d.font.glyph(r)
+	if gid == 0 {
+		r = '?'
+		gid = d.font.glyph(r)
+	}
+	if _, ok := d.used[gid]; !ok {
+		d.used[gid] = r
+	}
+	return gid
+}
+
+func (d *Document) encode(text string) string {
+	var sb strings.Builder
+	if d.font != nil {
+		for _, r := range text {
+			fmt.Fprintf(&sb, "%04X", d.glyph(r))
+		}
+		return sb.String()
+	}
+
+	for _, b := range winAnsi(text) {
+		fmt.Fprintf(&sb, "%02X", b)
+	}
+	return sb.String()
+}
+
+// WriteTo сериализация документа
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	out := &pdfWriter{w: bufio.NewWriter(w)}
+	out.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	const (
+		catalogID = 1
+		pagesID   = 2
+		fontID    = 3
+		infoID    = 4
+	)
+	nextID := infoID + 1
+	newID := func() int {
+		id := nextID
+		nextID++
+		return id
+	}
+
+	pageIDs := make([]int, len(d.pages))
+	contentIDs := make([]int, len(d.pages))
+	for i := range d.pages {
+		pageIDs[i] = newID()
+		contentIDs[i] = newID()
+	}
+
+	out.object(catalogID, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesID))
+
+	kids := make([]string, len(pageIDs))
+	for i, id := range pageIDs {
+		kids[i] = fmt.Sprintf("%d 0 R", id)
+	}
+	out.object(pagesID, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pageIDs)))
+
+	if d.font != nil {
+		d.writeTrueTypeFont(out, fontID, newID)
+	} else {
+		out.object(fontID, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	}
+
+	out.object(infoID, fmt.Sprintf("<< /Title <FEFF%s> /Producer (odo24) >>", utf16Hex(d.title)))
+
+	for i, content := range d.pages {
+		out.object(pageIDs[i], fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 %d 0 R >> >> /Contents %d 0 R >>",
+			pagesID, num(d.width), num(d.height), fontID, contentIDs[i]))
+		out.stream(contentIDs[i], "", content.Bytes())
+	}
+
+	out.trailer(catalogID, infoID)
+	if out.err != nil {
+		return out.n, out.err
+	}
+	return out.n, out.w.(*bufio.Writer).Flush()
+}
+
+func (d *Document) writeTrueTypeFont(out *pdfWriter, fontID int, newID func() int) {
+	cidFontID := newID()
+	descriptorID := newID()
+	fontFileID := newID()
+	toUnicodeID := newID()
+
+	gids := make([]int, 0, len(d.used))
+	for gid := range d.used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+
+	var widths strings.Builder
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, "%d [%s] ", gid, num(d.font.advance(uint16(gid))))
+	}
+
+	font := d.font
+	out.object(fontID, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /ReportFont /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		cidFontID, toUnicodeID))
+	out.object(cidFontID, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /ReportFont /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /CIDToGIDMap /Identity /DW 1000 /W [%s] >>",
+		descriptorID, widths.String()))
+	out.object(descriptorID, fmt.Sprintf("<< /Type /FontDescriptor /FontName /ReportFont /Flags 32 /FontBBox [%s %s %s %s] /ItalicAngle 0 /Ascent %s /Descent %s /CapHeight %s /StemV 80 /FontFile2 %d 0 R >>",
+		num(font.scale(int(font.bbox[0]))), num(font.scale(int(font.bbox[1]))), num(font.scale(int(font.bbox[2]))), num(font.scale(int(font.bbox[3]))),
+		num(font.scale(int(font.ascent))), num(font.scale(int(font.descent))), num(font.scale(int(font.ascent))), fontFileID))
+	out.stream(fontFileID, fmt.Sprintf("/Length1 %d ", len(font.data)), font.data)
+	out.stream(toUnicodeID, "", d.toUnicodeCMap(gids))
+}
+
+// toUnicodeCMap соответствие глифов символам, чтобы текст из PDF можно было копировать и искать
+func (d *Document) toUnicodeCMap(gids []int) []byte {
+	var b bytes.Buffer
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	b.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	b.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	b.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+
+	for start := 0; start < len(gids); start += 100 {
+		end := start + 100
+		if end > len(gids) {
+			end = len(gids)
+		}
+		fmt.Fprintf(&b, "%d beginbfchar\n", end-start)
+		for _, gid := range gids[start:end] {
+			fmt.Fprintf(&b, "<%04X> <%s>\n", gid, utf16Hex(string(d.used[uint16(gid)])))
+		}
+		b.WriteString("endbfchar\n")
+	}
+
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.Bytes()
+}
+
+// pdfWriter запись объектов с учетом смещений для таблицы xref
+type pdfWriter struct {
+	w       io.Writer
+	n       int64
+	err     error
+	offsets map[int]int64
+}
+
+func (p *pdfWriter) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.n += int64(n)
+	p.err = err
+}
+
+func (p *pdfWriter) write(data []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(data)
+	p.n += int64(n)
+	p.err = err
+}
+
+func (p *pdfWriter) begin(id int) {
+	if p.offsets == nil {
+		p.offsets = make(map[int]int64)
+	}
+	p.offsets[id] = p.n
+	p.printf("%d 0 obj\n", id)
+}
+
+func (p *pdfWriter) object(id int, body string) {
+	p.begin(id)
+	p.printf("%s\nendobj\n", body)
+}
+
+// stream поток со сжатием FlateDecode, extra - дополнительные ключи словаря
+func (p *pdfWriter) stream(id int, extra string, data []byte) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+
+	p.begin(id)
+	p.printf("<< %s/Length %d /Filter /FlateDecode >>\nstream\n", extra, compressed.Len())
+	p.write(compressed.Bytes())
+	p.printf("\nendstream\nendobj\n")
+}
+
+func (p *pdfWriter) trailer(rootID, infoID int) {
+	size := 0
+	for id := range p.offsets {
+		if id > size {
+			size = id
+		}
+	}
+	size++
+
+	xref := p.n
+	p.printf("xref\n0 %d\n0000000000 65535 f \n", size)
+	for id := 1; id < size; id++ {
+		p.printf("%010d 00000 n \n", p.offsets[id])
+	}
+	p.printf("trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", size, rootID, infoID, xref)
+}
+
+func num(value float64) string {
+	s := fmt.Sprintf("%.2f", value)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+// utf16Hex строка в UTF-16BE, для текстовых строк PDF перед ней ставится BOM
+func utf16Hex(text string) string {
+	var sb strings.Builder
+	for _, unit := range utf16.Encode([]rune(text)) {
+		fmt.Fprintf(&sb, "%04X", unit)
+	}
+	return sb.String()
+}
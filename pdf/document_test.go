@@ -0,0 +1,228 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// testGlyphs символы тестового шрифта и их глифы, ширины глифов - testAdvances
+var testGlyphs = map[rune]uint16{'A': 1, 'Ж': 2, '?': 3}
+
+var testAdvances = []uint16{250, 500, 600, 700}
+
+// buildTestFont минимальный TrueType: head, hhea, hmtx и cmap формата 4
+func buildTestFont() []byte {
+	head := make([]byte, 54)
+	binary.BigEndian.PutUint16(head[18:], 1000)
+	for i, v := range []int16{0, -200, 1000, 800} {
+		binary.BigEndian.PutUint16(head[36+i*2:], uint16(v))
+	}
+
+	hhea := make([]byte, 36)
+	ascent, descent := int16(800), int16(-200)
+	binary.BigEndian.PutUint16(hhea[4:], uint16(ascent))
+	binary.BigEndian.PutUint16(hhea[6:], uint16(descent))
+	binary.BigEndian.PutUint16(hhea[34:], uint16(len(testAdvances)))
+
+	hmtx := make([]byte, len(testAdvances)*4)
+	for i, advance := range testAdvances {
+		binary.BigEndian.PutUint16(hmtx[i*4:], advance)
+	}
+
+	codes := make([]int, 0, len(testGlyphs))
+	for r := range testGlyphs {
+		codes = append(codes, int(r))
+	}
+	sort.Ints(codes)
+	codes = append(codes, 0xffff)
+
+	segCount := len(codes)
+	subtable := make([]byte, 16+segCount*8)
+	binary.BigEndian.PutUint16(subtable, 4)
+	binary.BigEndian.PutUint16(subtable[2:], uint16(len(subtable)))
+	binary.BigEndian.PutUint16(subtable[6:], uint16(segCount*2))
+	for i, code := range codes {
+		gid := uint16(1)
+		if code != 0xffff {
+			gid = testGlyphs[rune(code)]
+		}
+		binary.BigEndian.PutUint16(subtable[14+i*2:], uint16(code))
+		binary.BigEndian.PutUint16(subtable[16+segCount*2+i*2:], uint16(code))
+		binary.BigEndian.PutUint16(subtable[16+segCount*4+i*2:], gid-uint16(code))
+	}
+
+	cmap := make([]byte, 12, 12+len(subtable))
+	binary.BigEndian.PutUint16(cmap[2:], 1)
+	binary.BigEndian.PutUint16(cmap[4:], 3)
+	binary.BigEndian.PutUint16(cmap[6:], 1)
+	binary.BigEndian.PutUint32(cmap[8:], 12)
+	cmap = append(cmap, subtable...)
+
+	tables := []struct {
+		tag  string
+		data []byte
+	}{{"cmap", cmap}, {"head", head}, {"hhea", hhea}, {"hmtx", hmtx}}
+
+	font := make([]byte, 12+len(tables)*16)
+	binary.BigEndian.PutUint32(font, 0x00010000)
+	binary.BigEndian.PutUint16(font[4:], uint16(len(tables)))
+	for i, table := range tables {
+		record := font[12+i*16:]
+		copy(record, table.tag)
+		binary.BigEndian.PutUint32(record[8:], uint32(len(font)))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(table.data)))
+		font = append(font, table.data...)
+		for len(font)%4 != 0 {
+			font = append(font, 0)
+		}
+	}
+	return font
+}
+
+func TestParseTrueTypeFont(t *testing.T) {
+	font, err := ParseTrueTypeFont(buildTestFont())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for r, gid := range testGlyphs {
+		if got := font.glyph(r); got != gid {
+			t.Errorf("glyph(%q) = %d, want %d", r, got, gid)
+		}
+	}
+	if got := font.glyph('Б'); got != 0 {
+		t.Errorf("glyph of missing rune = %d, want 0", got)
+	}
+	if got := font.advance(2); got != 600 {
+		t.Errorf("advance(2) = %v, want 600", got)
+	}
+
+	_, err = ParseTrueTypeFont(buildTestFont()[:40])
+	if !errors.Is(err, ErrInvalidFont) {
+		t.Errorf("truncated font: err = %v, want ErrInvalidFont", err)
+	}
+}
+
+var (
+	startxrefPattern = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+	xrefPattern      = regexp.MustCompile(`^xref\n0 (\d+)\n`)
+	refPattern       = regexp.MustCompile(`/ToUnicode (\d+) 0 R`)
+	fontFilePattern  = regexp.MustCompile(`/FontFile2 (\d+) 0 R`)
+	streamPattern    = regexp.MustCompile(`^\d+ 0 obj\n<< (?:/Length1 (\d+) )?/Length (\d+) /Filter /FlateDecode >>\nstream\n`)
+)
+
+// TestDocumentStructure смещения xref указывают на объекты, ToUnicode и ширины соответствуют использованным глифам
+func TestDocumentStructure(t *testing.T) {
+	fontData := buildTestFont()
+	font, err := ParseTrueTypeFont(fontData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := NewDocument(font)
+	doc.SetTitle("Отчет")
+	doc.AddPage()
+	doc.Text(50, 50, 12, "AЖ€")
+	doc.AddPage()
+	doc.Text(50, 50, 12, "A")
+
+	var buf bytes.Buffer
+	n, err := doc.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	if n != int64(len(out)) {
+		t.Fatalf("WriteTo returned %d, written %d", n, len(out))
+	}
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) {
+		t.Fatal("missing PDF header")
+	}
+
+	match := startxrefPattern.FindSubmatch(out)
+	if match == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	match = xrefPattern.FindSubmatch(out[xref:])
+	if match == nil {
+		t.Fatalf("startxref %d does not point to xref table", xref)
+	}
+	size, _ := strconv.Atoi(string(match[1]))
+
+	offsets := make(map[int]int, size)
+	entries := out[xref+len(match[0])+20:]
+	for id := 1; id < size; id++ {
+		entry := string(entries[(id-1)*20 : id*20])
+		offset, err := strconv.Atoi(entry[:10])
+		if err != nil || entry[10:] != " 00000 n \n" {
+			t.Fatalf("malformed xref entry %d: %q", id, entry)
+		}
+		prefix := fmt.Sprintf("%d 0 obj\n", id)
+		if !bytes.HasPrefix(out[offset:], []byte(prefix)) {
+			t.Fatalf("xref offset %d of object %d points to %q", offset, id, out[offset:offset+len(prefix)])
+		}
+		offsets[id] = offset
+	}
+
+	if !bytes.Contains(out, []byte("/W [1 [500] 2 [600] 3 [700] ]")) {
+		t.Error("glyph widths of used glyphs not found")
+	}
+
+	match = refPattern.FindSubmatch(out)
+	if match == nil {
+		t.Fatal("font has no ToUnicode")
+	}
+	id, _ := strconv.Atoi(string(match[1]))
+	cmap, _ := readStream(t, out[offsets[id]:])
+	for _, want := range []string{"3 beginbfchar", "<0001> <0041>", "<0002> <0416>", "<0003> <003F>"} {
+		if !bytes.Contains(cmap, []byte(want)) {
+			t.Errorf("ToUnicode has no %q:\n%s", want, cmap)
+		}
+	}
+
+	match = fontFilePattern.FindSubmatch(out)
+	if match == nil {
+		t.Fatal("font file is not embedded")
+	}
+	id, _ = strconv.Atoi(string(match[1]))
+	embedded, length1 := readStream(t, out[offsets[id]:])
+	if !bytes.Equal(embedded, fontData) || length1 != len(fontData) {
+		t.Errorf("embedded font differs: %d bytes, Length1 %d, want %d", len(embedded), length1, len(fontData))
+	}
+}
+
+// readStream распакованное содержимое потока объекта и значение /Length1
+func readStream(t *testing.T, object []byte) ([]byte, int) {
+	t.Helper()
+
+	match := streamPattern.FindSubmatch(object)
+	if match == nil {
+		t.Fatalf("not a stream object: %q", object[:40])
+	}
+	length, _ := strconv.Atoi(string(match[2]))
+	data := object[len(match[0]):]
+	if !bytes.HasPrefix(data[length:], []byte("\nendstream\nendobj\n")) {
+		t.Fatal("stream /Length does not match data")
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data[:length]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	length1, _ := strconv.Atoi(string(match[1]))
+	return body, length1
+}
@@ -0,0 +1,265 @@
+package pdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrInvalidFont     = errors.New("invalid truetype font")
+	ErrUnsupportedCmap = errors.New("font has no unicode cmap")
+)
+
+/*
+TrueTypeFont шрифт TrueType для встраивания в документ целиком.
+Из файла читаются только таблицы, нужные для кодировки Identity-H: cmap, метрики и габариты
+*/
+type TrueTypeFont struct {
+	data       []byte
+	unitsPerEm uint16
+	bbox       [4]int16
+	ascent     int16
+	descent    int16
+	advances   []uint16
+	glyphs     map[rune]uint16
+}
+
+// LoadTrueTypeFont чтение .ttf файла
+func LoadTrueTypeFont(path string) (*TrueTypeFont, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTrueTypeFont(data)
+}
+
+func ParseTrueTypeFont(data []byte) (*TrueTypeFont, error) {
+	tables, err := readTableDirectory(data)
+	if err != nil {
+		return nil, err
+	}
+
+	font := &TrueTypeFont{
+		data: data,
+	}
+
+	head, err := tableData(data, tables, "head", 54)
+	if err != nil {
+		return nil, err
+	}
+	font.unitsPerEm = binary.BigEndian.Uint16(head[18:])
+	if font.unitsPerEm == 0 {
+		return nil, ErrInvalidFont
+	}
+	for i := range font.bbox {
+		font.bbox[i] = int16(binary.BigEndian.Uint16(head[36+i*2:]))
+	}
+
+	hhea, err := tableData(data, tables, "hhea", 36)
+	if err != nil {
+		return nil, err
+	}
+	font.ascent = int16(binary.BigEndian.Uint16(hhea[4:]))
+	font.descent = int16(binary.BigEndian.Uint16(hhea[6:]))
+	numberOfHMetrics := int(binary.BigEndian.Uint16(hhea[34:]))
+
+	hmtx, err := tableData(data, tables, "hmtx", numberOfHMetrics*4)
+	if err != nil {
+		return nil, err
+	}
+	font.advances = make([]uint16, numberOfHMetrics)
+	for i := range font.advances {
+		font.advances[i] = binary.BigEndian.Uint16(hmtx[i*4:])
+	}
+
+	cmap, err := tableData(data, tables, "cmap", 4)
+	if err != nil {
+		return nil, err
+	}
+	font.glyphs, err = parseCmap(cmap)
+	if err != nil {
+		return nil, err
+	}
+
+	return font, nil
+}
+
+// glyph индекс глифа символа, 0 - глиф .notdef
+func (f *TrueTypeFont) glyph(r rune) uint16 {
+	return f.glyphs[r]
+}
+
+// advance ширина глифа в тысячных долях кегля
+func (f *TrueTypeFont) advance(gid uint16) float64 {
+	if len(f.advances) == 0 {
+		return 0
+	}
+	width := f.advances[len(f.advances)-1]
+	if int(gid) < len(f.advances) {
+		width = f.advances[gid]
+	}
+	return f.scale(int(width))
+}
+
+func (f *TrueTypeFont) scale(value int) float64 {
+	return float64(value) * 1000 / float64(f.unitsPerEm)
+}
+
+type tableRecord struct {
+	offset uint32
+	length uint32
+}
+
+func readTableDirectory(data []byte) (map[string]tableRecord, error) {
+	if len(data) < 12 {
+		return nil, ErrInvalidFont
+	}
+
+	version := binary.BigEndian.Uint32(data)
+	if version != 0x00010000 && version != 0x74727565 {
+		return nil, fmt.Errorf("%w: unsupported sfnt version %#x", ErrInvalidFont, version)
+	}
+
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 12+numTables*16 {
+		return nil, ErrInvalidFont
+	}
+
+	tables := make(map[string]tableRecord, numTables)
+	for i := 0; i < numTables; i++ {
+		record := data[12+i*16:]
+		tables[string(record[:4])] = tableRecord{
+			offset: binary.BigEndian.Uint32(record[8:]),
+			length: binary.BigEndian.Uint32(record[12:]),
+		}
+	}
+	return tables, nil
+}
+
+func tableData(data []byte, tables map[string]tableRecord, tag string, minLength int) ([]byte, error) {
+	record, ok := tables[tag]
+	if !ok {
+		return nil, fmt.Errorf("%w: table %s not found", ErrInvalidFont, tag)
+	}
+
+	end := uint64(record.offset) + uint64(record.length)
+	if end > uint64(len(data)) || int(record.length) < minLength {
+		return nil, fmt.Errorf("%w: table %s is truncated", ErrInvalidFont, tag)
+	}
+	return data[record.offset:end], nil
+}
+
+// parseCmap таблица символов Unicode: формат 12 (полный Unicode), либо формат 4 (BMP)
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	numTables := int(binary.BigEndian.Uint16(cmap[2:]))
+	if len(cmap) < 4+numTables*8 {
+		return nil, ErrInvalidFont
+	}
+
+	var format4, format12 []byte
+	for i := 0; i < numTables; i++ {
+		record := cmap[4+i*8:]
+		platformID := binary.BigEndian.Uint16(record)
+		encodingID := binary.BigEndian.Uint16(record[2:])
+		offset := binary.BigEndian.Uint32(record[4:])
+		if uint64(offset)+4 > uint64(len(cmap)) {
+			continue
+		}
+
+		isUnicode := platformID == 0 || (platformID == 3 && (encodingID == 1 || encodingID == 10))
+		if !isUnicode {
+			continue
+		}
+
+		subtable := cmap[offset:]
+		switch binary.BigEndian.Uint16(subtable) {
+		case 4:
+			format4 = subtable
+		case 12:
+			format12 = subtable
+		}
+	}
+
+	if format12 != nil {
+		return parseCmapFormat12(format12)
+	}
+	if format4 != nil {
+		return parseCmapFormat4(format4)
+	}
+	return nil, ErrUnsupportedCmap
+}
+
+func parseCmapFormat4(subtable []byte) (map[rune]uint16, error) {
+	if len(subtable) < 14 {
+		return nil, ErrInvalidFont
+	}
+
+	segCount := int(binary.BigEndian.Uint16(subtable[6:]) / 2)
+	endCodes := 14
+	startCodes := endCodes + segCount*2 + 2
+	idDeltas := startCodes + segCount*2
+	idRangeOffsets := idDeltas + segCount*2
+	if len(subtable) < idRangeOffsets+segCount*2 {
+		return nil, ErrInvalidFont
+	}
+
+	glyphs := make(map[rune]uint16)
+	for i := 0; i < segCount; i++ {
+		end := int(binary.BigEndian.Uint16(subtable[endCodes+i*2:]))
+		start := int(binary.BigEndian.Uint16(subtable[startCodes+i*2:]))
+		delta := binary.BigEndian.Uint16(subtable[idDeltas+i*2:])
+		rangeOffsetPos := idRangeOffsets + i*2
+		rangeOffset := int(binary.BigEndian.Uint16(subtable[rangeOffsetPos:]))
+
+		for c := start; c <= end && c != 0xffff; c++ {
+			var gid uint16
+			if rangeOffset == 0 {
+				gid = uint16(c) + delta
+			} else {
+				pos := rangeOffsetPos + rangeOffset + (c-start)*2
+				if pos+2 > len(subtable) {
+					continue
+				}
+				gid = binary.BigEndian.Uint16(subtable[pos:])
+				if gid != 0 {
+					gid += delta
+				}
+			}
+			if gid != 0 {
+				glyphs[rune(c)] = gid
+			}
+		}
+	}
+	return glyphs, nil
+}
+
+func parseCmapFormat12(subtable []byte) (map[rune]uint16, error) {
+	if len(subtable) < 16 {
+		return nil, ErrInvalidFont
+	}
+
+	numGroups := int(binary.BigEndian.Uint32(subtable[12:]))
+	if len(subtable) < 16+numGroups*12 {
+		return nil, ErrInvalidFont
+	}
+
+	glyphs := make(map[rune]uint16)
+	for i := 0; i < numGroups; i++ {
+		group := subtable[16+i*12:]
+		start := binary.BigEndian.Uint32(group)
+		end := binary.BigEndian.Uint32(group[4:])
+		startGlyph := binary.BigEndian.Uint32(group[8:])
+		if end < start || end > 0x10ffff {
+			continue
+		}
+		for c := start; c <= end; c++ {
+			gid := startGlyph + (c - start)
+			if gid != 0 && gid <= 0xffff {
+				glyphs[rune(c)] = uint16(gid)
+			}
+		}
+	}
+	return glyphs, nil
+}
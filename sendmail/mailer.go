@@ -0,0 +1,174 @@
+package sendmail
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	MailerSMTP   = "smtp"
+	MailerFile   = "file"
+	MailerLog    = "log"
+	MailerMemory = "memory"
+)
+
+var ErrUnknownTransport = errors.New("unknown mail transport")
+
+// Mailer транспорт отправки готового письма
+type Mailer interface {
+	Send(from, to, body string) error
+	Close() error
+}
+
+// idleCloser транспорт с постоянным соединением, которое стоит закрывать при простое
+type idleCloser interface {
+	CloseIdle()
+}
+
+// MailerOptions транспорт по имени из настроек, Dir - каталог для MailerFile
+type MailerOptions struct {
+	Transport string
+	Dir       string
+	SMTP      SMTPOptions
+}
+
+/*
+NewMailerFactory создание транспорта для воркера очереди, пустое имя - SMTP.
+SMTP соединение у каждого воркера свое, остальные транспорты общие
+*/
+func NewMailerFactory(options MailerOptions) (func() Mailer, error) {
+	var shared Mailer
+	switch options.Transport {
+	case "", MailerSMTP:
+		return func() Mailer {
+			return NewSMTPMailer(options.SMTP)
+		}, nil
+	case MailerFile:
+		shared = NewFileMailer(options.Dir)
+	case MailerLog:
+		shared = NewLogMailer()
+	case MailerMemory:
+		shared = NewMemoryMailer()
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTransport, options.Transport)
+	}
+
+	return func() Mailer {
+		return shared
+	}, nil
+}
+
+// FileMailer письма сохраняются файлами .eml в каталог, для разработки без почтового сервера
+type FileMailer struct {
+	dir string
+	seq atomic.Uint64
+}
+
+func NewFileMailer(dir string) *FileMailer {
+	return &FileMailer{
+		dir: dir,
+	}
+}
+
+func (m *FileMailer) Send(from, to, body string) error {
+	err := os.MkdirAll(m.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%d-%s.eml", time.Now().Format("20060102-150405.000"), m.seq.Add(1), safeFileName(to))
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(body), 0o600)
+}
+
+func (m *FileMailer) Close() error {
+	return nil
+}
+
+func safeFileName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// LogMailer письма только пишутся в лог
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(from, to, body string) error {
+	log.Printf("mail from %s to %s:\n%s", from, to, body)
+	return nil
+}
+
+func (m *LogMailer) Close() error {
+	return nil
+}
+
+// SentMessage письмо, перехваченное MemoryMailer
+type SentMessage struct {
+	From string
+	To   string
+	Body string
+}
+
+// MemoryMailer письма сохраняются в памяти процесса, для проверки отправленных писем и кодов в тестах
+type MemoryMailer struct {
+	mx       sync.Mutex
+	messages []SentMessage
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(from, to, body string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.messages = append(m.messages, SentMessage{From: from, To: to, Body: body})
+	return nil
+}
+
+func (m *MemoryMailer) Close() error {
+	return nil
+}
+
+// Messages копия всех перехваченных писем в порядке отправки
+func (m *MemoryMailer) Messages() []SentMessage {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	return append([]SentMessage(nil), m.messages...)
+}
+
+// Last последнее письмо на адрес to
+func (m *MemoryMailer) Last(to string) (SentMessage, bool) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if strings.EqualFold(m.messages[i].To, to) {
+			return m.messages[i], true
+		}
+	}
+	return SentMessage{}, false
+}
+
+func (m *MemoryMailer) Reset() {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.messages = nil
+}
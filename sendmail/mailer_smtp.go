@@ -0,0 +1,79 @@
+package sendmail
+
+import (
+	"log"
+	"time"
+
+	email "github.com/mil-ast/sendmail"
+)
+
+// smtpIdleTimeout соединение без писем дольше закрывается, серверы сами рвут простаивающие соединения
+const smtpIdleTimeout = time.Second * 30
+
+// SMTPOptions параметры SMTP сервера, Login - он же адрес отправителя
+type SMTPOptions struct {
+	Host     string
+	Port     uint16
+	Login    string
+	Password string
+}
+
+// SMTPMailer отправка через SMTP, соединение переиспользуется для следующих писем.
+// Не потокобезопасен, у каждого воркера свой экземпляр
+type SMTPMailer struct {
+	options  SMTPOptions
+	client   *email.Client
+	lastUsed time.Time
+}
+
+func NewSMTPMailer(options SMTPOptions) *SMTPMailer {
+	return &SMTPMailer{
+		options: options,
+	}
+}
+
+func (m *SMTPMailer) Send(from, to, body string) error {
+	m.CloseIdle()
+
+	if m.client == nil {
+		client, err := email.NewClient(email.Options{
+			Host:     m.options.Host,
+			Port:     m.options.Port,
+			Login:    m.options.Login,
+			Password: m.options.Password,
+		})
+		if err != nil {
+			return err
+		}
+		m.client = &client
+	}
+
+	err := m.client.Send(from, to, body)
+	if err != nil {
+		// состояние сессии после ошибки неизвестно, следующее письмо - через новое соединение
+		m.Close()
+		return err
+	}
+
+	m.lastUsed = time.Now()
+	return nil
+}
+
+// CloseIdle закрытие простаивающего соединения
+func (m *SMTPMailer) CloseIdle() {
+	if m.client != nil && time.Since(m.lastUsed) > smtpIdleTimeout {
+		m.Close()
+	}
+}
+
+func (m *SMTPMailer) Close() error {
+	if m.client == nil {
+		return nil
+	}
+	err := m.client.Quit()
+	if err != nil {
+		log.Printf("smtp quit error: %v", err)
+	}
+	m.client = nil
+	return nil
+}
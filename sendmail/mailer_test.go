@@ -0,0 +1,32 @@
+package sendmail
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestMailerFactory MailerMemory - общий транспорт воркеров, неизвестное имя - ошибка, а не молчаливый SMTP
+func TestMailerFactory(t *testing.T) {
+	factory, err := NewMailerFactory(MailerOptions{Transport: MailerMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer, ok := factory().(*MemoryMailer)
+	if !ok || factory() != Mailer(mailer) {
+		t.Fatal("memory transport must be one MemoryMailer shared by workers")
+	}
+
+	err = mailer.Send("from@example.com", "User@example.com", "body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, ok := mailer.Last("user@example.com")
+	if !ok || message.Body != "body" {
+		t.Fatalf("last message = %+v, %v", message, ok)
+	}
+
+	_, err = NewMailerFactory(MailerOptions{Transport: "smpt"})
+	if !errors.Is(err, ErrUnknownTransport) {
+		t.Fatalf("err = %v, want ErrUnknownTransport", err)
+	}
+}
@@ -0,0 +1,258 @@
+package sendmail
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"odo24_mobile_backend/db"
+	"time"
+)
+
+// статусы писем в очереди
+const (
+	StatusPending = "pending"
+	StatusSending = "sending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+const (
+	defaultOutboxWorkers     = 2
+	defaultOutboxMaxAttempts = 8
+	outboxPollInterval       = time.Second * 5
+	// outboxLease письмо, взятое воркером, возвращается в очередь, если воркер не ответил за это время
+	outboxLease      = time.Minute * 5
+	retryBaseDelay   = time.Second * 30
+	retryMaxDelay    = time.Hour
+	sentRetentionTTL = time.Hour * 24 * 30
+	maxErrorLength   = 1000
+)
+
+// OutboxOptions число воркеров и попыток отправки, после MaxAttempts письмо получает статус failed
+type OutboxOptions struct {
+	Workers     int
+	MaxAttempts int
+}
+
+// Outbox воркеры отправки писем из очереди, транспорт каждого воркера создает newMailer
+type Outbox struct {
+	newMailer   func() Mailer
+	workers     int
+	maxAttempts int
+}
+
+func NewOutbox(newMailer func() Mailer, options OutboxOptions) *Outbox {
+	if options.Workers <= 0 {
+		options.Workers = defaultOutboxWorkers
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultOutboxMaxAttempts
+	}
+
+	return &Outbox{
+		newMailer:   newMailer,
+		workers:     options.Workers,
+		maxAttempts: options.MaxAttempts,
+	}
+}
+
+/*
+outboxMessage взятое воркером письмо. Attempts растет при каждом захвате и служит меткой аренды:
+обновить состояние может только воркер, захвативший письмо последним
+*/
+type outboxMessage struct {
+	MessageID uint64
+	Recipient string
+	Body      string
+	Attempts  int
+}
+
+// errLeaseLost аренда истекла и письмо уже взял другой воркер, его состояние не трогаем
+var errLeaseLost = errors.New("outbox lease lost")
+
+var outboxWake = make(chan struct{}, 1)
+
+/*
+Enqueue письмо сохраняется в очередь и отправляется воркером Outbox.
+userID - владелец письма, удаляется вместе с ним; 0 - письмо на адрес без аккаунта (регистрация, восстановление)
+*/
+func Enqueue(userID uint64, to string, tplID uint8, params map[string]interface{}) error {
+	body, err := render(to, tplID, params)
+	if err != nil {
+		return err
+	}
+
+	var owner *uint64
+	if userID != 0 {
+		owner = &userID
+	}
+	var expiresAt *time.Time
+	if maxAge, ok := maxAges[tplID]; ok {
+		t := time.Now().Add(maxAge)
+		expiresAt = &t
+	}
+
+	pg := db.Conn()
+	_, err = pg.Exec(`INSERT INTO profiles.email_outbox (user_id,recipient,template,body,expires_at) VALUES ($1,$2,$3,$4,$5)`, owner, to, files[tplID], body, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start запуск воркеров отправки и очистки старых писем
+func (o *Outbox) Start() {
+	for i := 0; i < o.workers; i++ {
+		go o.runWorker()
+	}
+	go runOutboxCleanup(time.Hour)
+}
+
+func (o *Outbox) runWorker() {
+	mailer := o.newMailer()
+	defer mailer.Close()
+
+	for {
+		found, err := o.SendNext(mailer)
+		if err != nil {
+			log.Printf("outbox claim error: %v", err)
+			time.Sleep(outboxPollInterval)
+			continue
+		}
+
+		if !found {
+			select {
+			case <-outboxWake:
+			case <-time.After(outboxPollInterval):
+				if c, ok := mailer.(idleCloser); ok {
+					c.CloseIdle()
+				}
+			}
+		}
+	}
+}
+
+/*
+SendNext отправка следующего письма очереди через mailer, false - очередь пуста.
+Ошибка отправки не возвращается: письмо уходит на повтор, ошибка только в лог
+*/
+func (o *Outbox) SendNext(mailer Mailer) (bool, error) {
+	message, err := claimMessage()
+	if err != nil || message == nil {
+		return false, err
+	}
+
+	err = mailer.Send(from, message.Recipient, message.Body)
+	if err != nil {
+		log.Printf("outbox message_id=%d attempt %d error: %v", message.MessageID, message.Attempts, err)
+		err = o.markRetry(message, err)
+	} else {
+		err = markSent(message)
+	}
+	if err != nil {
+		log.Printf("outbox message_id=%d update error: %v", message.MessageID, err)
+	}
+	return true, nil
+}
+
+// claimMessage следующее письмо к отправке, в том числе зависшее у упавшего воркера, кроме устаревших. nil - очередь пуста
+func claimMessage() (*outboxMessage, error) {
+	pg := db.Conn()
+
+	var message outboxMessage
+	err := pg.QueryRow(`UPDATE profiles.email_outbox SET status=$1,attempts=attempts+1,locked_until=now()+make_interval(secs => $2)
+		WHERE message_id=(SELECT o.message_id FROM profiles.email_outbox o
+			WHERE ((o.status=$3 AND o.next_attempt_at<=now()) OR (o.status=$1 AND o.locked_until<now()))
+			AND (o.expires_at IS NULL OR o.expires_at>now())
+			ORDER BY o.next_attempt_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING message_id,recipient,body,attempts`, StatusSending, outboxLease.Seconds(), StatusPending).
+		Scan(&message.MessageID, &message.Recipient, &message.Body, &message.Attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// markSent тело отправленного письма не хранится
+func markSent(message *outboxMessage) error {
+	pg := db.Conn()
+
+	result, err := pg.Exec(`UPDATE profiles.email_outbox SET status=$1,sent_at=now(),locked_until=null,last_error='',body=''
+		WHERE message_id=$2 AND status=$3 AND attempts=$4`, StatusSent, message.MessageID, StatusSending, message.Attempts)
+	return checkLease(result, err)
+}
+
+// markRetry повтор с экспоненциальной задержкой, после последней попытки - статус failed без тела письма
+func (o *Outbox) markRetry(message *outboxMessage, sendErr error) error {
+	pg := db.Conn()
+
+	lastError := sendErr.Error()
+	if len(lastError) > maxErrorLength {
+		lastError = lastError[:maxErrorLength]
+	}
+
+	if message.Attempts >= o.maxAttempts {
+		result, err := pg.Exec(`UPDATE profiles.email_outbox SET status=$1,locked_until=null,last_error=$2,body=''
+			WHERE message_id=$3 AND status=$4 AND attempts=$5`, StatusFailed, lastError, message.MessageID, StatusSending, message.Attempts)
+		return checkLease(result, err)
+	}
+
+	result, err := pg.Exec(`UPDATE profiles.email_outbox SET status=$1,locked_until=null,last_error=$2,next_attempt_at=now()+make_interval(secs => $3)
+		WHERE message_id=$4 AND status=$5 AND attempts=$6`,
+		StatusPending, lastError, retryDelay(message.Attempts).Seconds(), message.MessageID, StatusSending, message.Attempts)
+	return checkLease(result, err)
+}
+
+// checkLease письмо не обновилось - его аренда перешла к другому воркеру
+func checkLease(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+func retryDelay(attempts int) time.Duration {
+	delay := retryMaxDelay
+	if attempts < 20 {
+		if d := retryBaseDelay << (attempts - 1); d < delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+/*
+runOutboxCleanup удаление устаревших писем с кодами сразу, остальных - через sentRetentionTTL.
+Неотправленные письма (failed) хранятся тот же срок без тела, для разбора ошибок
+*/
+func runOutboxCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		pg := db.Conn()
+		_, err := pg.Exec(`DELETE FROM profiles.email_outbox
+			WHERE (expires_at<now() AND status<>$1) OR created_at<now()-make_interval(secs => $2)`, StatusSending, sentRetentionTTL.Seconds())
+		if err != nil {
+			log.Printf("outbox cleanup error: %v", err)
+		}
+	}
+}
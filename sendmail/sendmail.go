@@ -6,73 +6,101 @@ import (
 	"html/template"
 	"io/ioutil"
 	"odo24_mobile_backend/config"
-
-	email "github.com/mil-ast/sendmail"
+	"time"
 )
 
 // Типы сообщений
 const (
 	TypeConfirmEmail uint8 = iota
 	TypeRepairConfirmCode
+	TypeChangeEmailCode
+	TypeChangeEmailNotify
+	TypeAccountDeleteCode
+	TypeExportReady
+	TypeServiceReminder
 )
 
-var templates map[uint8]string
+var files = map[uint8]string{
+	TypeConfirmEmail:      "confirm_email",
+	TypeRepairConfirmCode: "confirm_repair_code",
+	TypeChangeEmailCode:   "change_email_code",
+	TypeChangeEmailNotify: "change_email_notify",
+	TypeAccountDeleteCode: "account_delete_code",
+	TypeExportReady:       "export_ready",
+	TypeServiceReminder:   "service_reminder",
+}
 
-// InitSendmail инициализация почтовика
-func InitSendmail() {
-	templates = make(map[uint8]string)
+// maxAges срок жизни писем с кодами: код к этому времени уже недействителен, такое письмо не отправляется
+var maxAges = map[uint8]time.Duration{
+	TypeConfirmEmail:      time.Minute * 15,
+	TypeRepairConfirmCode: time.Minute * 15,
+	TypeChangeEmailCode:   time.Minute * 15,
+	TypeAccountDeleteCode: time.Minute * 15,
+}
+
+var (
+	templates map[uint8]string
+	// from адрес отправителя
+	from string
+)
 
-	files := map[uint8]string{
-		TypeConfirmEmail:      "confirm_email",
-		TypeRepairConfirmCode: "confirm_repair_code",
+// InitSendmail инициализация почтовика, возвращает фабрику выбранного в настройках транспорта для NewOutbox
+func InitSendmail() func() Mailer {
+	options := config.GetInstance()
+	from = options.SMTP.From
+
+	factory, err := NewMailerFactory(MailerOptions{
+		Transport: options.Mail.Transport,
+		Dir:       options.Mail.Dir,
+		SMTP: SMTPOptions{
+			Host:     options.SMTP.Host,
+			Port:     options.SMTP.Port,
+			Login:    options.SMTP.From,
+			Password: options.SMTP.Password,
+		},
+	})
+	if err != nil {
+		panic(err)
 	}
 
-	var (
-		body []byte
-		err  error
-	)
+	err = LoadTemplates("./sendmail")
+	if err != nil {
+		panic(err)
+	}
+	return factory
+}
 
+// LoadTemplates шаблоны писем из каталога dir
+func LoadTemplates(dir string) error {
+	templates = make(map[uint8]string)
 	for index, fileName := range files {
-		body, err = ioutil.ReadFile(fmt.Sprintf("./sendmail/%s.eml", fileName))
+		body, err := ioutil.ReadFile(fmt.Sprintf("%s/%s.eml", dir, fileName))
 		if err != nil {
-			panic(err)
+			return err
 		}
 		templates[index] = string(body)
 	}
+	return nil
 }
 
-// SendEmail отправка
-func SendEmail(to string, tplID uint8, params map[string]interface{}) error {
+// render письмо по шаблону, готовое к отправке
+func render(to string, tplID uint8, params map[string]interface{}) (string, error) {
 	templateBody, ok := templates[tplID]
 	if !ok {
-		return fmt.Errorf("Template %d not found", tplID)
+		return "", fmt.Errorf("Template %d not found", tplID)
 	}
 
-	options := config.GetInstance()
-
-	client, err := email.NewClient(email.Options{
-		Host:     options.SMTP.Host,
-		Port:     options.SMTP.Port,
-		Login:    options.SMTP.From,
-		Password: options.SMTP.Password,
-	})
-	if err != nil {
-		return err
-	}
-
-	templateBody = fmt.Sprintf(templateBody, options.SMTP.From, to)
+	templateBody = fmt.Sprintf(templateBody, from, to)
 
 	buffer := new(bytes.Buffer)
-	t := template.Must(template.New("letter").Parse(templateBody))
-	err = t.Execute(buffer, params)
+	t, err := template.New("letter").Parse(templateBody)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	err = client.Send(options.SMTP.From, to, buffer.String())
+	err = t.Execute(buffer, params)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	return client.Quit()
+	return buffer.String(), nil
 }